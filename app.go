@@ -12,6 +12,8 @@ import (
 	status "github.com/Financial-Times/service-status-go/httphandlers"
 	"github.com/gorilla/mux"
 	"github.com/jawher/mow.cli"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rcrowley/go-metrics"
 	log "github.com/sirupsen/logrus"
 )
@@ -28,28 +30,210 @@ func main() {
 	berthaAuthorsSrcUrl := app.String(cli.StringOpt{
 		Name:   "bertha-authors-source-url",
 		Value:  "{url}",
-		Desc:   "The URL of the Bertha Authors JSON source",
+		Desc:   "The URL of the Bertha Authors JSON source, or a file:// URL of a local JSON file or directory of JSON fixtures",
 		EnvVar: "BERTHA_AUTHORS_SOURCE_URL",
 	})
 	berthaRolesSrcUrl := app.String(cli.StringOpt{
 		Name:   "bertha-roles-source-url",
 		Value:  "{url}",
-		Desc:   "The URL of the Bertha Roles JSON source",
+		Desc:   "The URL of the Bertha Roles JSON source, or a file:// URL of a local JSON file or directory of JSON fixtures",
 		EnvVar: "BERTHA_ROLES_SOURCE_URL",
 	})
 
+	sourceFormat := app.String(cli.StringOpt{
+		Name:   "source-format",
+		Value:  autoFormat,
+		Desc:   "Format of the authors and roles sources: json, csv, or auto to choose it by the Content-Type of the response",
+		EnvVar: "SOURCE_FORMAT",
+	})
+	maxSourceBytes := app.Int(cli.IntOpt{
+		Name:   "max-source-bytes",
+		Value:  10 << 20,
+		Desc:   "Largest response accepted from the authors or roles source, in bytes",
+		EnvVar: "MAX_SOURCE_BYTES",
+	})
+	minAuthors := app.Int(cli.IntOpt{
+		Name:   "min-authors",
+		Value:  1,
+		Desc:   "Fewest authors a refresh accepts, so a cleared sheet doesn't delete every membership",
+		EnvVar: "MIN_AUTHORS",
+	})
+	minRoles := app.Int(cli.IntOpt{
+		Name:   "min-roles",
+		Value:  1,
+		Desc:   "Fewest roles a refresh accepts",
+		EnvVar: "MIN_ROLES",
+	})
+	maxMembershipDropPercent := app.Int(cli.IntOpt{
+		Name:   "max-membership-drop-percent",
+		Value:  25,
+		Desc:   "Refuse a refresh that drops more than this percentage of the memberships, unless forced. Zero disables the check",
+		EnvVar: "MAX_MEMBERSHIP_DROP_PERCENT",
+	})
+	maxMembershipDrop := app.Int(cli.IntOpt{
+		Name:   "max-membership-drop",
+		Value:  0,
+		Desc:   "Refuse a refresh that drops more than this number of memberships, unless forced. Zero disables the check",
+		EnvVar: "MAX_MEMBERSHIP_DROP",
+	})
+	skipInvalidRecords := app.Bool(cli.BoolOpt{
+		Name:   "skip-invalid-records",
+		Value:  false,
+		Desc:   "Skip the authors and roles that can't be transformed and publish the others, instead of failing the whole refresh",
+		EnvVar: "SKIP_INVALID_RECORDS",
+	})
+
+	snapshotDir := app.String(cli.StringOpt{
+		Name:   "snapshot-dir",
+		Value:  "",
+		Desc:   "Directory where every successful refresh is saved as a versioned snapshot, and the latest one is served from at startup. Empty disables it",
+		EnvVar: "SNAPSHOT_DIR",
+	})
+
+	refreshInterval := app.String(cli.StringOpt{
+		Name:   "refresh-interval",
+		Value:  "0s",
+		Desc:   "How often the cache is refreshed in the background, e.g. 15m. Zero disables the scheduled refresh",
+		EnvVar: "REFRESH_INTERVAL",
+	})
+	refreshJitter := app.String(cli.StringOpt{
+		Name:   "refresh-jitter",
+		Value:  "1m",
+		Desc:   "Maximum random delay added to every scheduled refresh, so that replicas don't call Bertha at the same moment",
+		EnvVar: "REFRESH_JITTER",
+	})
+	refreshOnCount := app.Bool(cli.BoolOpt{
+		Name:   "refresh-on-count",
+		Value:  false,
+		Desc:   "Refresh the cache every time the memberships count is requested, as if every call passed refresh=true",
+		EnvVar: "REFRESH_ON_COUNT",
+	})
+
+	initialLoadRetryDelay := app.String(cli.StringOpt{
+		Name:   "initial-load-retry-delay",
+		Value:  "1s",
+		Desc:   "Wait before retrying a failed initial load, doubled after every further failure",
+		EnvVar: "INITIAL_LOAD_RETRY_DELAY",
+	})
+	initialLoadMaxRetryDelay := app.String(cli.StringOpt{
+		Name:   "initial-load-max-retry-delay",
+		Value:  "2m",
+		Desc:   "Longest wait between two attempts of the initial load",
+		EnvVar: "INITIAL_LOAD_MAX_RETRY_DELAY",
+	})
+
+	berthaConnectTimeout := app.String(cli.StringOpt{
+		Name:   "bertha-connect-timeout",
+		Value:  defaultBerthaClientConfig.connectTimeout.String(),
+		Desc:   "Timeout of connecting to Bertha",
+		EnvVar: "BERTHA_CONNECT_TIMEOUT",
+	})
+	berthaReadTimeout := app.String(cli.StringOpt{
+		Name:   "bertha-read-timeout",
+		Value:  defaultBerthaClientConfig.readTimeout.String(),
+		Desc:   "Timeout of reading a whole response from Bertha once connected",
+		EnvVar: "BERTHA_READ_TIMEOUT",
+	})
+	berthaMaxRetries := app.Int(cli.IntOpt{
+		Name:   "bertha-max-retries",
+		Value:  defaultBerthaClientConfig.maxRetries,
+		Desc:   "How many times a call to Bertha is retried after a 5xx response or a network error",
+		EnvVar: "BERTHA_MAX_RETRIES",
+	})
+	berthaRetryDelay := app.String(cli.StringOpt{
+		Name:   "bertha-retry-delay",
+		Value:  defaultBerthaClientConfig.retryDelay.String(),
+		Desc:   "Wait before the first retry of a call to Bertha, doubled before every further retry",
+		EnvVar: "BERTHA_RETRY_DELAY",
+	})
+	berthaBreakerThreshold := app.Int(cli.IntOpt{
+		Name:   "bertha-breaker-threshold",
+		Value:  defaultBerthaClientConfig.breakerThreshold,
+		Desc:   "Consecutive failed calls to a Bertha sheet that open its circuit breaker",
+		EnvVar: "BERTHA_BREAKER_THRESHOLD",
+	})
+	berthaBreakerCooldown := app.String(cli.StringOpt{
+		Name:   "bertha-breaker-cooldown",
+		Value:  defaultBerthaClientConfig.breakerCooldown.String(),
+		Desc:   "How long an open circuit breaker stops the calls to Bertha before letting a trial call through",
+		EnvVar: "BERTHA_BREAKER_COOLDOWN",
+	})
+
+	maxRefreshAge := app.String(cli.StringOpt{
+		Name:   "max-refresh-age",
+		Value:  defaultHealthThresholds.maxRefreshAge.String(),
+		Desc:   "Age of the last successful refresh above which the service is reported unhealthy. Zero disables the check",
+		EnvVar: "MAX_REFRESH_AGE",
+	})
+	minMemberships := app.Int(cli.IntOpt{
+		Name:   "min-memberships",
+		Value:  defaultHealthThresholds.minMemberships,
+		Desc:   "Fewest memberships served before the service is reported unhealthy",
+		EnvVar: "MIN_MEMBERSHIPS",
+	})
+	healthCheckInterval := app.String(cli.StringOpt{
+		Name:   "health-check-interval",
+		Value:  defaultHealthCheckInterval.String(),
+		Desc:   "How often the health checks are run in the background. /__health returns the results of the last run",
+		EnvVar: "HEALTH_CHECK_INTERVAL",
+	})
+
 	app.Action = func() {
 		log.Info("App started!!!")
-		bs, err := newBerthaService(*berthaAuthorsSrcUrl, *berthaRolesSrcUrl)
+		interval := mustParseDuration("refresh interval", *refreshInterval)
+		jitter := mustParseDuration("refresh jitter", *refreshJitter)
+		retryDelay := mustParseDuration("initial load retry delay", *initialLoadRetryDelay)
+		maxRetryDelay := mustParseDuration("initial load max retry delay", *initialLoadMaxRetryDelay)
+
+		clientConfig := berthaClientConfig{
+			connectTimeout:   mustParseDuration("Bertha connect timeout", *berthaConnectTimeout),
+			readTimeout:      mustParseDuration("Bertha read timeout", *berthaReadTimeout),
+			maxRetries:       *berthaMaxRetries,
+			retryDelay:       mustParseDuration("Bertha retry delay", *berthaRetryDelay),
+			breakerThreshold: *berthaBreakerThreshold,
+			breakerCooldown:  mustParseDuration("Bertha breaker cooldown", *berthaBreakerCooldown),
+		}
+		if err := clientConfig.validate(); err != nil {
+			log.Fatalf("Invalid Bertha client configuration: %v", err)
+		}
+		client = newBerthaClient(clientConfig)
+
+		bs, err := newBerthaService(berthaServiceConfig{
+			authorsURL:               *berthaAuthorsSrcUrl,
+			rolesURL:                 *berthaRolesSrcUrl,
+			sourceFormat:             *sourceFormat,
+			maxSourceBytes:           int64(*maxSourceBytes),
+			minAuthors:               *minAuthors,
+			minRoles:                 *minRoles,
+			skipInvalidRecords:       *skipInvalidRecords,
+			maxMembershipDropPercent: *maxMembershipDropPercent,
+			maxMembershipDrop:        *maxMembershipDrop,
+			snapshotDir:              *snapshotDir,
+		})
 
 		if err != nil {
-			log.Error(err)
-			panic(err)
+			log.Fatalf("Invalid configuration: %v", err)
 		}
+		// The first refresh runs in the background, so the server listens even while Bertha hangs.
+		// Until it succeeds the endpoints report that the data is not loaded yet, or serve the snapshot read from disk.
+		newInitialLoader(bs, retryDelay, maxRetryDelay).start()
+
+		if interval > 0 {
+			newRefreshScheduler(bs, interval, jitter).start()
+		}
+
+		mh := newMembershipHandler(bs, *refreshOnCount, healthThresholds{
+			maxRefreshAge:  mustParseDuration("max refresh age", *maxRefreshAge),
+			minMemberships: *minMemberships,
+		})
+		ph := newPersonHandler(bs)
+		rh := newRoleHandler(bs)
+		promMetrics.register(prometheus.DefaultRegisterer, bs, bs)
 
-		mh := newMembershipHandler(bs)
+		hc := newHealthCache(mh.healthChecks(), mustParseDuration("health check interval", *healthCheckInterval))
+		hc.start()
 
-		h := setupServiceHandlers(mh)
+		h := setupServiceHandlers(mh, ph, rh, hc)
 
 		http.Handle("/", httphandlers.HTTPMetricsHandler(metrics.DefaultRegistry,
 			httphandlers.TransactionAwareRequestLoggingHandler(log.StandardLogger(), h)))
@@ -64,7 +248,15 @@ func main() {
 	app.Run(os.Args)
 }
 
-func setupServiceHandlers(mh membershipHandler) http.Handler {
+func mustParseDuration(name string, value string) time.Duration {
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Fatalf("Invalid %s: %v", name, err)
+	}
+	return d
+}
+
+func setupServiceHandlers(mh membershipHandler, ph personHandler, rh roleHandler, hc *healthCache) http.Handler {
 	r := mux.NewRouter()
 
 	timedHC := fthealth.TimedHealthCheck{
@@ -72,7 +264,7 @@ func setupServiceHandlers(mh membershipHandler) http.Handler {
 			SystemCode:  "curated-authors-memberships-tf",
 			Name:        "Curated Authors Memberships Transformer",
 			Description: "A REST service that transforms Authors data from Bertha to Memberships according to UPP format.",
-			Checks:      []fthealth.Check{mh.AuthorsHealthCheck(), mh.RolesHealthCheck()},
+			Checks:      hc.cachedChecks(),
 		},
 		Timeout: 10 * time.Second,
 	}
@@ -83,11 +275,24 @@ func setupServiceHandlers(mh membershipHandler) http.Handler {
 	r.HandleFunc(status.BuildInfoPath, status.BuildInfoHandler)
 	r.HandleFunc(status.BuildInfoPathDW, status.BuildInfoHandler)
 	r.HandleFunc(status.GTGPath, status.NewGoodToGoHandler(mh.GTG))
+	r.Handle("/__metrics", promhttp.Handler()).Methods("GET")
 
 	r.HandleFunc("/transformers/memberships/__reload", mh.refreshMembershipCache).Methods("POST")
+	r.HandleFunc(reloadJobsPath+"{id}", mh.getReloadJob).Methods("GET")
 	r.HandleFunc("/transformers/memberships/__count", mh.getMembershipsCount).Methods("GET")
 	r.HandleFunc("/transformers/memberships/__ids", mh.getMembershipUuids).Methods("GET")
+	r.HandleFunc("/transformers/memberships/__rejected", mh.getRejectedRecords).Methods("GET")
+	r.HandleFunc("/transformers/memberships/__changes", mh.getMembershipChanges).Methods("GET")
+	r.HandleFunc("/transformers/memberships/__status", mh.getCacheStatus).Methods("GET")
 	r.HandleFunc("/transformers/memberships/{uuid}", mh.getMembershipByUuid).Methods("GET")
 
+	r.HandleFunc("/transformers/people/__count", ph.getPeopleCount).Methods("GET")
+	r.HandleFunc("/transformers/people/__ids", ph.getPeopleUuids).Methods("GET")
+	r.HandleFunc("/transformers/people/{uuid}", ph.getPersonByUuid).Methods("GET")
+
+	r.HandleFunc("/transformers/roles/__count", rh.getRolesCount).Methods("GET")
+	r.HandleFunc("/transformers/roles/__ids", rh.getRoleUuids).Methods("GET")
+	r.HandleFunc("/transformers/roles/{uuid}", rh.getRoleByUuid).Methods("GET")
+
 	return r
 }
@@ -1,8 +1,115 @@
 package main
 
+import (
+	"encoding/json"
+	"strings"
+)
+
 // This struct reflects the JSON data model of curated authors from Bertha
 type author struct {
-	Role          string `json:"role"`
-	Jobtitle      string `json:"jobtitle"`
-	TmeIdentifier string `json:"tmeidentifier"`
+	Name          string      `json:"name"`
+	Roles         authorRoles `json:"role"`
+	Jobtitle      string      `json:"jobtitle"`
+	Email         string      `json:"email"`
+	ImageUrl      string      `json:"imageurl"`
+	Biography     string      `json:"biography"`
+	TwitterHandle string      `json:"twitterhandle"`
+	TmeIdentifier string      `json:"tmeidentifier"`
+	StartDate     string      `json:"startdate"`
+	EndDate       string      `json:"enddate"`
+}
+
+// A blank row of the sheet, kept so that the following authors keep their row number
+func (a author) isBlank() bool {
+	for _, value := range []string{a.Name, a.Jobtitle, a.Email, a.ImageUrl, a.Biography, a.TwitterHandle, a.TmeIdentifier, a.StartDate, a.EndDate} {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return len(a.Roles) == 0
+}
+
+func countAuthors(authors []author) int {
+	count := 0
+	for _, a := range authors {
+		if !a.isBlank() {
+			count++
+		}
+	}
+	return count
+}
+
+// A role of the author, optionally with its own dates when written as a JSON object in the role column
+type authorRole struct {
+	Name      string `json:"role"`
+	StartDate string `json:"startdate"`
+	EndDate   string `json:"enddate"`
+}
+
+// The role column holds one role, a list of roles separated by semicolons or new lines,
+// or a JSON array of roles, either as a real array or written as text in the cell.
+// The elements of a JSON array are role names or role objects with dates.
+type authorRoles []authorRole
+
+// Commas are not separators, as they are found in preflabels such as "Editor, Weekend"
+const roleSeparators = ";\n"
+
+func (ar *authorRoles) UnmarshalJSON(data []byte) error {
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err == nil {
+		roles, err := decodeRoleElements(elements)
+		if err != nil {
+			return err
+		}
+		*ar = roles
+		return nil
+	}
+
+	var cell string
+	if err := json.Unmarshal(data, &cell); err != nil {
+		return err
+	}
+	*ar = parseRoleCell(cell)
+	return nil
+}
+
+func parseRoleCell(cell string) authorRoles {
+	cell = strings.TrimSpace(cell)
+	if strings.HasPrefix(cell, "[") {
+		var elements []json.RawMessage
+		if err := json.Unmarshal([]byte(cell), &elements); err == nil {
+			if roles, err := decodeRoleElements(elements); err == nil {
+				return roles
+			}
+		}
+	}
+	roles := authorRoles{}
+	for _, name := range strings.FieldsFunc(cell, func(r rune) bool {
+		return strings.ContainsRune(roleSeparators, r)
+	}) {
+		roles = appendRole(roles, authorRole{Name: name})
+	}
+	return roles
+}
+
+func decodeRoleElements(elements []json.RawMessage) (authorRoles, error) {
+	roles := authorRoles{}
+	for _, e := range elements {
+		var r authorRole
+		if err := json.Unmarshal(e, &r.Name); err != nil {
+			if err := json.Unmarshal(e, &r); err != nil {
+				return nil, err
+			}
+		}
+		roles = appendRole(roles, r)
+	}
+	return roles, nil
+}
+
+func appendRole(roles authorRoles, r authorRole) authorRoles {
+	r.Name = strings.TrimSpace(r.Name)
+	if r.Name == "" {
+		return roles
+	}
+	return append(roles, r)
 }
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldDecodeRoleColumnVariants(t *testing.T) {
+	columnist := authorRole{Name: "Columnist"}
+	editor := authorRole{Name: "Editor"}
+	cases := map[string]authorRoles{
+		`"Columnist"`:                        {columnist},
+		`"Columnist; Editor"`:                {columnist, editor},
+		`"Editor, Weekend"`:                  {{Name: "Editor, Weekend"}},
+		`"Columnist;Editor; "`:               {columnist, editor},
+		`"[\"Columnist\", \"Editor\"]"`:      {columnist, editor},
+		`["Columnist", " Editor "]`:          {columnist, editor},
+		`""`:                                 {},
+		`null`:                               {},
+		`"Columnist\nAssociate Editor"`:      {columnist, {Name: "Associate Editor"}},
+		`"Work & Career Columnist; Editor "`: {{Name: "Work & Career Columnist"}, editor},
+		`["Columnist", {"role": "Editor", "startdate": "2015-01-01", "enddate": "2016-01-01"}]`: {columnist, {Name: "Editor", StartDate: "2015-01-01", EndDate: "2016-01-01"}},
+		`"[{\"role\": \"Editor\", \"startdate\": \"2015-01-01\"}]"`:                             {{Name: "Editor", StartDate: "2015-01-01"}},
+	}
+
+	for column, expected := range cases {
+		var a author
+		err := json.Unmarshal([]byte(`{"role": `+column+`}`), &a)
+		assert.Nil(t, err, "Role column %s should be decoded", column)
+		assert.Equal(t, expected, a.Roles, "Role column %s should be decoded", column)
+	}
+}
+
+func TestShouldFailToDecodeRoleColumnOfWrongType(t *testing.T) {
+	var a author
+	err := json.Unmarshal([]byte(`{"role": 42}`), &a)
+	assert.NotNil(t, err)
+
+	err = json.Unmarshal([]byte(`{"role": ["Columnist", 42]}`), &a)
+	assert.NotNil(t, err)
+}
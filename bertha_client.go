@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/gregjones/httpcache"
+	log "github.com/sirupsen/logrus"
+)
+
+type berthaClientConfig struct {
+	connectTimeout time.Duration
+	// Time allowed for the response, including reading the whole body
+	readTimeout time.Duration
+	maxRetries  int
+	// Wait before the first retry, doubled before every further one
+	retryDelay time.Duration
+	// Consecutive failed calls that open the circuit breaker of a source, and how long it stays open
+	breakerThreshold int
+	breakerCooldown  time.Duration
+}
+
+var defaultBerthaClientConfig = berthaClientConfig{
+	connectTimeout:   5 * time.Second,
+	readTimeout:      30 * time.Second,
+	maxRetries:       2,
+	retryDelay:       500 * time.Millisecond,
+	breakerThreshold: 5,
+	breakerCooldown:  time.Minute,
+}
+
+// A negative number of retries would retry forever, and a threshold below 1 would open the breaker on the first failure
+func (c berthaClientConfig) validate() error {
+	if c.maxRetries < 0 {
+		return fmt.Errorf("max retries must not be negative, got %d", c.maxRetries)
+	}
+	if c.breakerThreshold < 1 {
+		return fmt.Errorf("breaker threshold must be at least 1, got %d", c.breakerThreshold)
+	}
+	return nil
+}
+
+// Calls Bertha through an HTTP cache, retrying 5xx responses and network errors with backoff
+type berthaClient struct {
+	config berthaClientConfig
+	http   *http.Client
+}
+
+var client = newBerthaClient(defaultBerthaClientConfig)
+
+func newBerthaClient(config berthaClientConfig) *berthaClient {
+	cache := httpcache.NewMemoryCacheTransport()
+	cache.Transport = &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   config.connectTimeout,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   config.connectTimeout,
+		ResponseHeaderTimeout: config.readTimeout,
+		MaxIdleConns:          10,
+		IdleConnTimeout:       90 * time.Second,
+	}
+	return &berthaClient{
+		config: config,
+		http: &http.Client{
+			Transport: cache,
+			Timeout:   config.connectTimeout + config.readTimeout,
+		},
+	}
+}
+
+func (c *berthaClient) newCircuitBreaker() *circuitBreaker {
+	return newCircuitBreaker(c.config.breakerThreshold, c.config.breakerCooldown)
+}
+
+// A call fails for the circuit breaker only once all its retries have failed, and a cancelled call doesn't fail at all.
+// The last 5xx response is returned as it is, so the caller can report its status.
+func (c *berthaClient) get(ctx context.Context, url string, breaker *circuitBreaker) (*http.Response, error) {
+	if err := breaker.allow(); err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+
+	delay := c.config.retryDelay
+	for attempt := 0; ; attempt++ {
+		started := time.Now()
+		resp, err := c.http.Do(req)
+		promMetrics.observeFetch(url, started, resp)
+		if err == nil && resp.StatusCode < http.StatusInternalServerError {
+			breaker.success()
+			return resp, nil
+		}
+		if ctx.Err() != nil {
+			breaker.cancelled()
+			return nil, ctx.Err()
+		}
+		if attempt == c.config.maxRetries {
+			breaker.failure()
+			return resp, err
+		}
+
+		fields := log.Fields{"bertha_url": url, "attempt": attempt + 1, "retryIn": delay}
+		if err != nil {
+			log.WithFields(fields).WithError(err).Warn("Calling Bertha failed")
+		} else {
+			log.WithFields(fields).Warnf("Bertha returned HTTP status %d", resp.StatusCode)
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			breaker.cancelled()
+			return nil, ctx.Err()
+		}
+		delay *= 2
+	}
+}
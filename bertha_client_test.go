@@ -0,0 +1,140 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testBerthaClient() *berthaClient {
+	return newBerthaClient(berthaClientConfig{
+		connectTimeout:   time.Second,
+		readTimeout:      100 * time.Millisecond,
+		maxRetries:       2,
+		retryDelay:       time.Millisecond,
+		breakerThreshold: 2,
+		breakerCooldown:  time.Minute,
+	})
+}
+
+// Answers with the given statuses in turn, then with the last one
+func statusSequenceServer(calls *int32, statuses ...int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := int(atomic.AddInt32(calls, 1))
+		if n > len(statuses) {
+			n = len(statuses)
+		}
+		w.WriteHeader(statuses[n-1])
+	}))
+}
+
+func TestShouldRetryBerthaUntilItSucceeds(t *testing.T) {
+	var calls int32
+	server := statusSequenceServer(&calls, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusOK)
+	defer server.Close()
+
+	c := testBerthaClient()
+	breaker := c.newCircuitBreaker()
+	resp, err := c.get(context.Background(), server.URL, breaker)
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls), "Bertha should be called once and retried twice")
+	assert.Equal(t, circuitClosed, breaker.currentState())
+}
+
+func TestShouldReturnTheLast5xxResponseWhenRetriesAreExhausted(t *testing.T) {
+	var calls int32
+	server := statusSequenceServer(&calls, http.StatusInternalServerError)
+	defer server.Close()
+
+	c := testBerthaClient()
+	resp, err := c.get(context.Background(), server.URL, c.newCircuitBreaker())
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+	assert.Equal(t, int32(3), atomic.LoadInt32(&calls))
+}
+
+func TestShouldNotRetry4xxResponses(t *testing.T) {
+	var calls int32
+	server := statusSequenceServer(&calls, http.StatusNotFound)
+	defer server.Close()
+
+	c := testBerthaClient()
+	resp, err := c.get(context.Background(), server.URL, c.newCircuitBreaker())
+	assert.Nil(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls))
+}
+
+func TestShouldTimeOutWhenBerthaHangs(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	c := testBerthaClient()
+	start := time.Now()
+	_, err := c.get(context.Background(), server.URL, c.newCircuitBreaker())
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 5*time.Second, "The call should not wait for Bertha indefinitely")
+}
+
+func TestShouldStopCallingBerthaWhenTheCircuitIsOpen(t *testing.T) {
+	var calls int32
+	server := statusSequenceServer(&calls, http.StatusInternalServerError)
+	defer server.Close()
+
+	c := testBerthaClient()
+	breaker := c.newCircuitBreaker()
+	for i := 0; i < 2; i++ {
+		resp, err := c.get(context.Background(), server.URL, breaker)
+		assert.Nil(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, circuitOpen, breaker.currentState())
+
+	_, err := c.get(context.Background(), server.URL, breaker)
+	assert.IsType(t, &circuitOpenError{}, err)
+	assert.Equal(t, int32(6), atomic.LoadInt32(&calls), "Bertha should not be called while the circuit is open")
+}
+
+func TestShouldNotCountConnectivityProbesInTheCircuitBreaker(t *testing.T) {
+	var calls int32
+	server := statusSequenceServer(&calls, http.StatusInternalServerError)
+	defer server.Close()
+
+	src := newBerthaSource(server.URL)
+	for i := 0; i <= defaultBerthaClientConfig.breakerThreshold; i++ {
+		assert.Error(t, src.checkConnectivity())
+	}
+	assert.Equal(t, circuitClosed, src.breaker.currentState(), "Failed probes should not open the circuit")
+
+	for i := 0; i < defaultBerthaClientConfig.breakerThreshold; i++ {
+		src.breaker.failure()
+	}
+	before := atomic.LoadInt32(&calls)
+	assert.EqualError(t, src.checkConnectivity(), "Bertha returns unexpected HTTP status: 500")
+	assert.True(t, atomic.LoadInt32(&calls) > before, "Probes should reach Bertha while the circuit is open")
+}
+
+func TestShouldRejectClientConfigThatRetriesForeverOrOpensOnTheFirstFailure(t *testing.T) {
+	assert.Nil(t, defaultBerthaClientConfig.validate())
+
+	config := defaultBerthaClientConfig
+	config.maxRetries = -1
+	assert.EqualError(t, config.validate(), "max retries must not be negative, got -1")
+
+	config = defaultBerthaClientConfig
+	config.breakerThreshold = 0
+	assert.EqualError(t, config.validate(), "breaker threshold must be at least 1, got 0")
+}
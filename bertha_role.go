@@ -1,7 +1,24 @@
 package main
 
+import "strings"
+
 type berthaRole struct {
 	UUID       string `json:"uuid"`
 	Preflabel  string `json:"preflabel"`
 	ParentUUID string `json:"parentUuid,omitempty"`
 }
+
+// A blank row of the sheet, kept so that the following roles keep their row number
+func (r berthaRole) isBlank() bool {
+	return strings.TrimSpace(r.UUID) == "" && strings.TrimSpace(r.Preflabel) == "" && strings.TrimSpace(r.ParentUUID) == ""
+}
+
+func countRoles(roles []berthaRole) int {
+	count := 0
+	for _, r := range roles {
+		if !r.isBlank() {
+			count++
+		}
+	}
+	return count
+}
@@ -1,140 +1,485 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"errors"
 	"fmt"
-	"net/http"
+	"strings"
 	"sync"
+	"time"
 
-	"github.com/gregjones/httpcache"
 	log "github.com/sirupsen/logrus"
 )
 
-var client = httpcache.NewMemoryCacheTransport().Client()
-
 type berthaService struct {
-	authorsUrl     string
-	rolesUrl       string
-	membershipsMap map[string]membership
-	transformer    transformer
-	mutex          *sync.Mutex
+	authorsSource      source
+	rolesSource        source
+	sourceFormat       string
+	maxSourceBytes     int64
+	minAuthors         int
+	minRoles           int
+	skipInvalidRecords bool
+	guard              massDeletionGuard
+	snapshot           *snapshot
+	lastRefreshErr     error
+	// The refusal of the last refresh by the mass deletion guard, until a refresh is applied
+	massDeletionErr error
+	// Whether a refresh from the sources has succeeded since the start, as opposed to serving a snapshot from disk
+	refreshed bool
+	// Start and duration of the last refresh, successful or not
+	lastAttemptAt       time.Time
+	lastAttemptDuration time.Duration
+	changes             *changeLog
+	store               *snapshotStore
+	transformer         transformer
+	// Refreshes run one at a time, holding refreshMutex while Bertha is called. The mutex only guards
+	// the swap of the snapshot and the refresh state, so reads never wait for Bertha.
+	refreshMutex *sync.Mutex
+	mutex        *sync.RWMutex
+}
+
+type berthaServiceConfig struct {
+	authorsURL string
+	rolesURL   string
+	// Format of both sources, autoFormat chooses it by the Content-Type of every response
+	sourceFormat string
+	// Largest body accepted from a source, and the fewest authors and roles a refresh accepts. Zero disables the check.
+	maxSourceBytes     int64
+	minAuthors         int
+	minRoles           int
+	skipInvalidRecords bool
+	// Largest drop of the memberships count a refresh may cause, in percent and in number. Zero disables the check.
+	maxMembershipDropPercent int
+	maxMembershipDrop        int
+	// Directory where every successful refresh is saved, empty to keep the snapshots only in memory
+	snapshotDir string
 }
 
-func newBerthaService(authorsUrl string, rolesUrl string) (*berthaService, error) {
+// Only checks the configuration and reads back the latest saved snapshot. Bertha is not called,
+// the first refresh is left to the initial loader so the HTTP server starts straight away.
+func newBerthaService(config berthaServiceConfig) (*berthaService, error) {
+	authorsSource, err := newSource(config.authorsURL)
+	if err != nil {
+		return nil, err
+	}
+	rolesSource, err := newSource(config.rolesURL)
+	if err != nil {
+		return nil, err
+	}
+	if err := validateSourceFormat(config.sourceFormat); err != nil {
+		return nil, err
+	}
+
 	bs := &berthaService{
-		authorsUrl:  authorsUrl,
-		rolesUrl:    rolesUrl,
-		transformer: &berthaTransformer{},
-		mutex:       &sync.Mutex{},
+		authorsSource:      authorsSource,
+		rolesSource:        rolesSource,
+		sourceFormat:       config.sourceFormat,
+		maxSourceBytes:     config.maxSourceBytes,
+		minAuthors:         config.minAuthors,
+		minRoles:           config.minRoles,
+		skipInvalidRecords: config.skipInvalidRecords,
+		guard:              massDeletionGuard{maxDropPercent: config.maxMembershipDropPercent, maxDrop: config.maxMembershipDrop},
+		snapshot:           newSnapshot(),
+		changes:            newChangeLog(),
+		transformer:        &berthaTransformer{},
+		refreshMutex:       &sync.Mutex{},
+		mutex:              &sync.RWMutex{},
+	}
+
+	if config.snapshotDir != "" {
+		if bs.store, err = newSnapshotStore(config.snapshotDir); err != nil {
+			return nil, err
+		}
+		if s := bs.store.latest(); s != nil {
+			bs.changes.record(bs.snapshot.membershipHashes, s.membershipHashes)
+			bs.snapshot = s
+		}
+	}
+
+	// A snapshot saved by an earlier run is served straight away, while the initial loader calls Bertha
+	if bs.snapshot.loaded {
+		log.Infof("Serving snapshot %d created at %s until a refresh succeeds", bs.snapshot.version, bs.snapshot.createdAt.Format(time.RFC3339))
 	}
-	err := bs.refreshMembershipCache()
-	return bs, err
+	return bs, nil
 }
 
 func (bs *berthaService) refreshMembershipCache() error {
+	_, err := bs.refresh(false)
+	return err
+}
+
+// Refreshes, forced or not, and reports the counts of the snapshot this refresh applied,
+// whatever other refreshes swap in afterwards
+func (bs *berthaService) reloadMembershipCache(force bool) (refreshResult, error) {
+	s, err := bs.refresh(force)
+	if err != nil {
+		return refreshResult{}, err
+	}
+	return refreshResult{memberships: len(s.memberships), rejected: len(s.rejected)}, nil
+}
+
+// The new snapshot is built off to the side and swapped in only when the whole refresh succeeds,
+// so a failing refresh keeps serving the last good data
+func (bs *berthaService) refresh(force bool) (*snapshot, error) {
+	bs.refreshMutex.Lock()
+	defer bs.refreshMutex.Unlock()
+	started := time.Now()
+	defer bs.recordAttempt(started)
+	s, err := bs.loadSnapshot()
+	if err != nil {
+		if se, ok := err.(*sourceError); ok {
+			log.WithFields(log.Fields{"source": se.source, "reason": se.reason}).Error(err)
+		} else {
+			log.Error(err)
+		}
+		bs.setRefreshErrors(err, nil)
+		promMetrics.observeRefresh(started, err, nil)
+		return nil, err
+	}
+
+	previous := bs.currentSnapshot()
+	if !force && previous.loaded {
+		if err := bs.guard.check(len(previous.memberships), len(s.memberships)); err != nil {
+			log.WithField("reason", massDeletionReason).Error(err)
+			bs.setRefreshErrors(err, err)
+			promMetrics.observeRefresh(started, err, nil)
+			return nil, err
+		}
+	}
+	s.version = previous.version + 1
+	s.createdAt = time.Now().UTC()
+
 	bs.mutex.Lock()
-	defer bs.mutex.Unlock()
-	bs.membershipsMap = make(map[string]membership)
-	authResp, authErr := bs.callBerthaService(bs.authorsUrl)
-	if authErr != nil {
-		log.Error(authErr)
-		return authErr
+	bs.changes.record(previous.membershipHashes, s.membershipHashes)
+	bs.snapshot = s
+	bs.refreshed = true
+	bs.lastRefreshErr = nil
+	bs.massDeletionErr = nil
+	bs.mutex.Unlock()
+	log.WithFields(log.Fields{
+		"version":             s.version,
+		"authorsETag":         s.sources.Authors.ETag,
+		"authorsLastModified": s.sources.Authors.LastModified,
+		"rolesETag":           s.sources.Roles.ETag,
+		"rolesLastModified":   s.sources.Roles.LastModified,
+	}).Info("Memberships refreshed")
+	promMetrics.observeRefresh(started, nil, s.rejected)
+
+	if bs.store != nil {
+		if err := bs.store.save(s); err != nil {
+			log.Errorf("Failed to save snapshot %d: %v", s.version, err)
+		}
 	}
+	return s, nil
+}
 
-	defer authResp.Body.Close()
+func (bs *berthaService) recordAttempt(started time.Time) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	bs.lastAttemptAt = started.UTC()
+	bs.lastAttemptDuration = time.Since(started)
+}
 
-	var authors []author
-	if err := json.NewDecoder(authResp.Body).Decode(&authors); err != nil {
-		log.Error(err)
-		return err
+// The mass deletion refusal is kept until a refresh is applied
+func (bs *berthaService) setRefreshErrors(err error, massDeletionErr error) {
+	bs.mutex.Lock()
+	defer bs.mutex.Unlock()
+	bs.lastRefreshErr = err
+	if massDeletionErr != nil {
+		bs.massDeletionErr = massDeletionErr
 	}
+}
+
+// The served snapshot is never modified, so it can be read without holding the lock
+func (bs *berthaService) currentSnapshot() *snapshot {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	return bs.snapshot
+}
 
-	rolesResp, rolesErr := bs.callBerthaService(bs.rolesUrl)
-	if rolesErr != nil {
-		log.Error(rolesErr)
-		return rolesErr
+// Loads and transforms the current data and compares it with the served memberships, without applying it
+func (bs *berthaService) previewMembershipRefresh() (membershipDiff, error) {
+	s, err := bs.loadSnapshot()
+	if err != nil {
+		return membershipDiff{}, err
 	}
+	return diffMemberships(bs.currentSnapshot().memberships, s.memberships), nil
+}
 
-	defer rolesResp.Body.Close()
+// Both sheets are read at the same time. When one of them fails the other is cancelled,
+// and the first error is reported rather than the cancellation it caused.
+func (bs *berthaService) loadSnapshot() (*snapshot, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
 
+	var firstErr error
+	var once sync.Once
+	fail := func(err error) {
+		once.Do(func() {
+			firstErr = err
+			cancel()
+		})
+	}
+
+	var authors []author
 	var roles []berthaRole
-	if err := json.NewDecoder(rolesResp.Body).Decode(&roles); err != nil {
-		log.Error(err)
-		return err
+	var authorsRejected, rolesRejected []rejectedRecord
+	var versions sourcePair
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		var err error
+		opts := bs.sheetOptions(authorsSheet)
+		if versions.Authors, authorsRejected, err = readSource(ctx, bs.authorsSource, opts, &authors); err == nil {
+			err = checkMinimumRecords(bs.authorsSource, "authors", countAuthors(authors), bs.minAuthors)
+		}
+		if err != nil {
+			fail(err)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		var err error
+		opts := bs.sheetOptions(rolesSheet)
+		if versions.Roles, rolesRejected, err = readSource(ctx, bs.rolesSource, opts, &roles); err == nil {
+			err = checkMinimumRecords(bs.rolesSource, "roles", countRoles(roles), bs.minRoles)
+		}
+		if err != nil {
+			fail(err)
+		}
+	}()
+	wg.Wait()
+	if firstErr != nil {
+		return nil, firstErr
 	}
 
-	if err := bs.populateMembershipMap(authors, roles); err != nil {
-		log.Error(err)
-		return err
+	s, err := bs.buildSnapshot(authors, roles)
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	// The records rejected while decoding were left blank, so the transformation skipped them
+	decodeRejected := append(rolesRejected, authorsRejected...)
+	for _, r := range decodeRejected {
+		log.WithFields(log.Fields{"sheet": r.Sheet, "row": r.Row, "reason": r.Reason}).Warn(r.Message)
+	}
+	s.rejected = append(decodeRejected, s.rejected...)
+	s.authorCount = countAuthors(authors) + len(authorsRejected)
+	s.sources = versions
+	return s, nil
 }
 
-func (bs *berthaService) populateMembershipMap(authors []author, roles []berthaRole) error {
+func (bs *berthaService) sheetOptions(sheet string) sheetOptions {
+	return sheetOptions{sheet: sheet, format: bs.sourceFormat, maxBytes: bs.maxSourceBytes, skipInvalid: bs.skipInvalidRecords}
+}
+
+func (bs *berthaService) buildSnapshot(authors []author, roles []berthaRole) (*snapshot, error) {
+	s := newSnapshot()
 	nameRolesMap := make(map[string]berthaRole)
 	uuidRolesMap := make(map[string]berthaRole)
 
-	for _, r := range roles {
+	validRoles, problems := validateRoles(roles)
+	if len(problems) > 0 {
+		if !bs.skipInvalidRecords {
+			return nil, &roleGraphError{problems: problems}
+		}
+		for _, r := range problems {
+			log.WithFields(log.Fields{"row": r.Row, "reason": r.Reason}).Warn(r.Message)
+		}
+		s.rejected = append(s.rejected, problems...)
+	}
+
+	for _, r := range validRoles {
 		nameRolesMap[r.Preflabel] = r
 		uuidRolesMap[r.UUID] = r
+		s.roles[r.UUID] = bs.transformer.toRole(r)
 	}
 
-	for _, a := range authors {
+	for i, a := range authors {
+		if a.isBlank() {
+			continue
+		}
 		m, err := bs.transformer.toMembership(a, uuidRolesMap, nameRolesMap)
 		if err != nil {
-			bs.membershipsMap = make(map[string]membership)
-			return err
+			r := rejectedAuthor(i, a, err)
+			if !bs.skipInvalidRecords {
+				return nil, fmt.Errorf("Author at row %d is invalid: %s", r.Row, r.Message)
+			}
+			log.WithFields(log.Fields{"row": r.Row, "reason": r.Reason}).Warn(r.Message)
+			s.rejected = append(s.rejected, r)
+			continue
 		}
-		bs.membershipsMap[m.UUID] = m
+		s.memberships[m.UUID] = m
+		s.membershipHashes[m.UUID] = contentHash(m)
+
+		p := bs.transformer.toPerson(a)
+		s.people[p.Uuid] = p
 	}
-	return nil
+	s.contentHash = membershipsHash(s.membershipHashes)
+	s.loaded = true
+	return s, nil
+}
+
+func (bs *berthaService) isLoaded() bool {
+	return bs.currentSnapshot().loaded
+}
+
+func (bs *berthaService) hasRefreshed() bool {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	return bs.refreshed
+}
+
+func (bs *berthaService) staleReason() error {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	if !bs.snapshot.loaded {
+		return nil
+	}
+	return bs.lastRefreshErr
+}
+
+// Unlike staleReason, also reported while no data is loaded
+func (bs *berthaService) lastRefreshError() error {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	return bs.lastRefreshErr
+}
+
+// The source URLs are reported even before a snapshot is loaded
+func (bs *berthaService) getCacheStatus() cacheStatus {
+	bs.mutex.RLock()
+	s := bs.snapshot
+	lastAttemptAt := bs.lastAttemptAt
+	lastAttemptDuration := bs.lastAttemptDuration
+	lastRefreshErr := bs.lastRefreshErr
+	bs.mutex.RUnlock()
+
+	status := cacheStatus{
+		Version: s.version,
+		Sources: s.sources,
+		Counts: cacheCounts{
+			Authors:     s.authorCount,
+			Roles:       len(s.roles),
+			Memberships: len(s.memberships),
+			Rejected:    len(s.rejected),
+		},
+	}
+	if status.Sources.Authors.URL == "" {
+		status.Sources.Authors.URL = bs.authorsSource.String()
+	}
+	if status.Sources.Roles.URL == "" {
+		status.Sources.Roles.URL = bs.rolesSource.String()
+	}
+	if !lastAttemptAt.IsZero() {
+		status.LastAttemptAt = &lastAttemptAt
+		status.LastAttemptDuration = lastAttemptDuration.String()
+	}
+	if s.loaded {
+		createdAt := s.createdAt
+		status.LastSuccessAt = &createdAt
+		status.ContentHash = s.contentHash
+	}
+	if lastRefreshErr != nil {
+		status.LastError = lastRefreshErr.Error()
+		status.LastErrorReason = refreshErrorReason(lastRefreshErr)
+	}
+	return status
+}
+
+// Zero until a snapshot is loaded
+func (bs *berthaService) snapshotCreatedAt() time.Time {
+	return bs.currentSnapshot().createdAt
 }
 
 func (bs *berthaService) getMembershipCount() int {
-	bs.mutex.Lock()
-	defer bs.mutex.Unlock()
-	return len(bs.membershipsMap)
+	return len(bs.currentSnapshot().memberships)
 }
 
-func (bs *berthaService) getMembershipUuids() []string {
-	bs.mutex.Lock()
-	defer bs.mutex.Unlock()
+// The count and the UUIDs are read from one snapshot together with its content hash,
+// which unlike the version doesn't depend on how many refreshes the replica ran
+func (bs *berthaService) getMembershipCountWithVersion() (int, string) {
+	s := bs.currentSnapshot()
+	return len(s.memberships), s.contentHash
+}
+
+func (bs *berthaService) getMembershipUuidsWithVersion() ([]string, string) {
+	s := bs.currentSnapshot()
+	return s.membershipUuids(), s.contentHash
+}
+
+func (bs *berthaService) getMembershipByUuid(uuid string) membership {
+	return bs.currentSnapshot().memberships[uuid]
+}
+
+func (bs *berthaService) getRejectedRecords() []rejectedRecord {
+	return bs.currentSnapshot().rejected
+}
+
+func (bs *berthaService) getMembershipChangesSince(token string) (changeSet, error) {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	return bs.changes.changesSince(token)
+}
+
+func (bs *berthaService) getPersonCount() int {
+	return len(bs.currentSnapshot().people)
+}
+
+func (bs *berthaService) getPersonUuids() []string {
 	uuids := make([]string, 0)
-	for uuid, _ := range bs.membershipsMap {
+	for uuid := range bs.currentSnapshot().people {
 		uuids = append(uuids, uuid)
 	}
 	return uuids
 }
 
-func (bs *berthaService) getMembershipByUuid(uuid string) membership {
-	bs.mutex.Lock()
-	defer bs.mutex.Unlock()
-	return bs.membershipsMap[uuid]
+func (bs *berthaService) getPersonByUuid(uuid string) person {
+	return bs.currentSnapshot().people[uuid]
+}
+
+func (bs *berthaService) getRoleCount() int {
+	return len(bs.currentSnapshot().roles)
+}
+
+func (bs *berthaService) getRoleUuids() []string {
+	uuids := make([]string, 0)
+	for uuid := range bs.currentSnapshot().roles {
+		uuids = append(uuids, uuid)
+	}
+	return uuids
 }
 
-func (bs *berthaService) callBerthaService(url string) (res *http.Response, err error) {
-	log.WithFields(log.Fields{"bertha_url": url}).Info("Calling Bertha...")
-	res, err = client.Get(url)
-	return
+func (bs *berthaService) getRoleByUuid(uuid string) role {
+	return bs.currentSnapshot().roles[uuid]
 }
 
 func (bs *berthaService) checkAuthorsConnectivity() error {
-	return bs.checkConnectivity(bs.authorsUrl)
+	return bs.authorsSource.checkConnectivity()
 }
 
 func (bs *berthaService) checkRolesConnectivity() error {
-	return bs.checkConnectivity(bs.rolesUrl)
+	return bs.rolesSource.checkConnectivity()
 }
 
-func (bs *berthaService) checkConnectivity(url string) error {
-	resp, err := bs.callBerthaService(url)
-	if err != nil {
-		return err
+func (bs *berthaService) checkMassDeletionGuard() error {
+	bs.mutex.RLock()
+	defer bs.mutex.RUnlock()
+	return bs.massDeletionErr
+}
+
+// Reports the sources whose circuit breaker is open, so Bertha is not being called for them
+func (bs *berthaService) checkCircuitBreakers() error {
+	var open []string
+	for _, s := range []source{bs.authorsSource, bs.rolesSource} {
+		if cbs, ok := s.(circuitBreakerSource); ok {
+			if err := cbs.checkCircuitBreaker(); err != nil {
+				open = append(open, fmt.Sprintf("%s: %s", s, err.Error()))
+			}
+		}
 	}
-	defer resp.Body.Close()
-	if resp.StatusCode != http.StatusOK {
-		return errors.New(fmt.Sprintf("Bertha returns unexpected HTTP status: %d", resp.StatusCode))
+	if len(open) > 0 {
+		return errors.New(strings.Join(open, "; "))
 	}
 	return nil
 }
@@ -1,22 +1,30 @@
 package main
 
 import (
+	"crypto/md5"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/gorilla/handlers"
 	"github.com/gorilla/mux"
 	"github.com/stretchr/testify/assert"
 )
 
-const etag = "W/\"75e-78600296\""
 const authorsBerthaPath = "/view/publish/gss/123456XYZ/Authors"
 const rolesBerthaPath = "/view/publish/gss/123456XYZ/Roles"
 const authorsBerthaOutput = "test-resources/bertha-authors-output.json"
 const rolesBerthaOutput = "test-resources/bertha-roles-output.json"
+const invalidAuthorsBerthaOutput = "test-resources/bertha-invalid-authors-output.json"
+const cyclicRolesBerthaOutput = "test-resources/bertha-cyclic-roles-output.json"
 
 var membership1 = membership{
 	UUID:                   expectedMembershipUUID,
@@ -24,12 +32,30 @@ var membership1 = membership{
 	PersonUUID:             expectedAuthorUUID,
 	OrganisationUUID:       ftUUID,
 	AlternativeIdentifiers: alternativeIdentifiers{UUIDS: []string{expectedMembershipUUID}},
-	MembershipRoles:        []membershipRole{membershipRole{RoleUUID: "7ef75a6a-b6bf-4eb7-a1da-03e0acabef1b"}},
+	MembershipRoles:        []membershipRole{membershipRole{RoleUUID: "7ef75a6a-b6bf-4eb7-a1da-03e0acabef1b", InceptionDate: "2010-01-01T00:00:00.000Z"}},
+	InceptionDate:          "2008-05-01T00:00:00.000Z",
+	TerminationDate:        "2016-03-31T17:30:00.000Z",
 }
 var membership2 = membership{
 	UUID: "a1c08d1f-9c19-370b-af34-80aa6cf3c0ad",
 }
 
+// Calls to unhappy Bertha mocks are retried without the waits of a real deployment
+func init() {
+	config := defaultBerthaClientConfig
+	config.retryDelay = time.Millisecond
+	client = newBerthaClient(config)
+}
+
+// Builds the service and runs its first refresh, as the initial loader does at startup
+func newRefreshedBerthaService(config berthaServiceConfig) (*berthaService, error) {
+	bs, err := newBerthaService(config)
+	if err != nil {
+		return nil, err
+	}
+	return bs, bs.refreshMembershipCache()
+}
+
 type berthaMock struct {
 	server     *httptest.Server
 	outputFile string
@@ -53,9 +79,20 @@ func (mock *berthaMock) start(status string) {
 	mock.server = httptest.NewServer(r)
 }
 
+// The HTTP client caches responses by URL, and test servers may reuse the port of an earlier one,
+// so every output file needs its own ETag
+func (mock *berthaMock) etag() string {
+	return fmt.Sprintf("W/\"%x\"", md5.Sum([]byte(mock.outputFile)))
+}
+
 func (mock *berthaMock) berthaHandlerMock(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	if strings.HasSuffix(mock.outputFile, ".csv") {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
 	ifNoneMatch := r.Header.Get("If-None-Match")
+	etag := mock.etag()
 
 	if ifNoneMatch == etag {
 		w.WriteHeader(http.StatusNotModified)
@@ -85,7 +122,7 @@ func TestShouldReturnMembershipCount(t *testing.T) {
 	berthaRolesMock.start("happy")
 	defer berthaRolesMock.stop()
 
-	bs, err := newBerthaService(berthaAuthorsMock.getUrl(), berthaRolesMock.getUrl())
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
 	assert.Nil(t, err)
 
 	c := bs.getMembershipCount()
@@ -98,11 +135,11 @@ func TestShouldReturnMembershipsUuids(t *testing.T) {
 	berthaRolesMock.start("happy")
 	defer berthaRolesMock.stop()
 
-	bs, err := newBerthaService(berthaAuthorsMock.getUrl(), berthaRolesMock.getUrl())
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
 	assert.Nil(t, err)
 
 	bs.getMembershipCount()
-	uuids := bs.getMembershipUuids()
+	uuids, _ := bs.getMembershipUuidsWithVersion()
 
 	assert.Equal(t, 2, len(uuids), "Bertha should return 2 authors")
 	assert.Equal(t, true, contains(uuids, membership1.UUID), "actual UUIDS=%s should contain expected membership1 UUID=%s", uuids, membership1.UUID)
@@ -115,13 +152,16 @@ func TestShouldReturnSingleMembership(t *testing.T) {
 	berthaRolesMock.start("happy")
 	defer berthaRolesMock.stop()
 
-	bs, err := newBerthaService(berthaAuthorsMock.getUrl(), berthaRolesMock.getUrl())
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
 	assert.Nil(t, err)
 
 	bs.getMembershipCount()
 	m := bs.getMembershipByUuid(membership1.UUID)
 
 	assert.Equal(t, membership1, m, "The membership should be membership1")
+	undated := bs.getMembershipByUuid(membership2.UUID)
+	assert.Empty(t, undated.InceptionDate, "An author without dates should have none")
+	assert.Empty(t, undated.TerminationDate, "An author without dates should have none")
 }
 
 func TestShouldReturnEmptyMembershipWhenMembershipIsNotAvailable(t *testing.T) {
@@ -130,7 +170,7 @@ func TestShouldReturnEmptyMembershipWhenMembershipIsNotAvailable(t *testing.T) {
 	berthaRolesMock.start("happy")
 	defer berthaRolesMock.stop()
 
-	bs, err := newBerthaService(berthaAuthorsMock.getUrl(), berthaRolesMock.getUrl())
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
 	assert.Nil(t, err)
 
 	m := bs.getMembershipByUuid("7f8bd61a-3575-4d32-a758-0fa41cbcc826")
@@ -138,19 +178,136 @@ func TestShouldReturnEmptyMembershipWhenMembershipIsNotAvailable(t *testing.T) {
 	assert.Equal(t, membership{}, m, "The membership should be empty")
 }
 
+func TestShouldReturnPeopleFromTheSameAuthors(t *testing.T) {
+	berthaAuthorsMock.start("happy")
+	defer berthaAuthorsMock.stop()
+	berthaRolesMock.start("happy")
+	defer berthaRolesMock.stop()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, bs.getPersonCount(), "Bertha should return 2 people")
+
+	uuids := bs.getPersonUuids()
+	assert.Equal(t, true, contains(uuids, membership1.PersonUUID), "actual UUIDS=%s should contain the person of membership1 UUID=%s", uuids, membership1.PersonUUID)
+
+	p := bs.getPersonByUuid(membership1.PersonUUID)
+	assert.Equal(t, "Martin Wolf", p.Name)
+	assert.Equal(t, "@martinwolf_", p.TwitterHandle)
+	assert.Equal(t, []string{"Martin Wolf"}, p.Aliases)
+}
+
+func TestShouldReturnRolesReferencedByMemberships(t *testing.T) {
+	berthaAuthorsMock.start("happy")
+	defer berthaAuthorsMock.stop()
+	berthaRolesMock.start("happy")
+	defer berthaRolesMock.stop()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, bs.getRoleCount(), "Bertha should return 2 roles")
+	assert.Equal(t, 2, len(bs.getRoleUuids()), "Bertha should return 2 roles")
+
+	r := bs.getRoleByUuid(membership1.MembershipRoles[0].RoleUUID)
+	assert.Equal(t, "Columnist", r.PrefLabel)
+	assert.Equal(t, membershipRoleType, r.Type)
+}
+
+func TestShouldLoadMembershipsFromLocalFiles(t *testing.T) {
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: "file://test-resources/authors-fixtures", rolesURL: "file://" + rolesBerthaOutput})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, bs.getMembershipCount(), "The fixtures should contain 2 authors")
+	assert.Equal(t, membership1, bs.getMembershipByUuid(membership1.UUID), "The membership should be membership1")
+	assert.Nil(t, bs.checkAuthorsConnectivity())
+	assert.Nil(t, bs.checkRolesConnectivity())
+}
+
+func TestShouldLoadMembershipsFromGoogleSheetsCSVExports(t *testing.T) {
+	csvAuthorsMock := berthaMock{outputFile: "test-resources/google-sheets-authors-output.csv", path: authorsBerthaPath}
+	csvAuthorsMock.start("happy")
+	defer csvAuthorsMock.stop()
+	csvRolesMock := berthaMock{outputFile: "test-resources/google-sheets-roles-output.csv", path: rolesBerthaPath}
+	csvRolesMock.start("happy")
+	defer csvRolesMock.stop()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: csvAuthorsMock.getUrl(), rolesURL: csvRolesMock.getUrl(), sourceFormat: autoFormat})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 2, bs.getMembershipCount(), "The CSV export should contain 2 authors")
+	assert.Equal(t, membership1, bs.getMembershipByUuid(membership1.UUID), "The membership should be membership1")
+	assert.Equal(t, 2, len(bs.getMembershipByUuid(membership2.UUID).MembershipRoles), "The second author should have 2 roles")
+}
+
+func TestShouldReportTheSheetRowOfRejectedAuthorsAfterBlankRows(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sheets")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	authorsCSV := filepath.Join(dir, "authors.csv")
+	sheet := "Name,Role,TME Identifier\nMartin Wolf,Columnist,Q0ItMDAwMDkwMA==-QXV0aG9ycw==\n\nNo One,Villain,Tm9PbmU=\n"
+	assert.Nil(t, ioutil.WriteFile(authorsCSV, []byte(sheet), 0644))
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: "file://" + authorsCSV, rolesURL: "file://" + rolesBerthaOutput, skipInvalidRecords: true})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 1, bs.getMembershipCount())
+	rejected := bs.getRejectedRecords()
+	assert.Equal(t, 1, len(rejected))
+	assert.Equal(t, 4, rejected[0].Row, "The blank row 3 should be counted")
+	assert.Equal(t, unknownRoleReason, rejected[0].Reason)
+}
+
+func TestShouldSkipAuthorsWithFieldsOfTheWrongType(t *testing.T) {
+	dir, err := ioutil.TempDir("", "sheets")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+	authorsJSON := filepath.Join(dir, "authors.json")
+	sheet := `[
+		{"name": "Martin Wolf", "jobtitle": 2, "role": "Columnist", "tmeidentifier": "Q0ItMDAwMDkwMA==-QXV0aG9ycw=="},
+		{"name": "No One", "role": "Villain", "tmeidentifier": "Tm9PbmU="},
+		{"name": "Lucy Kellaway", "role": "Columnist", "tmeidentifier": "Q0ItMDAwMDkyNg==-QXV0aG9ycw=="}
+	]`
+	assert.Nil(t, ioutil.WriteFile(authorsJSON, []byte(sheet), 0644))
+
+	_, err = newRefreshedBerthaService(berthaServiceConfig{authorsURL: "file://" + authorsJSON, rolesURL: "file://" + rolesBerthaOutput})
+	assert.IsType(t, &sourceError{}, err, "A mistyped field should fail the refresh unless invalid records are skipped")
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: "file://" + authorsJSON, rolesURL: "file://" + rolesBerthaOutput, skipInvalidRecords: true})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, bs.getMembershipCount(), "Only the valid author should be published")
+	rejected := bs.getRejectedRecords()
+	assert.Equal(t, 2, len(rejected))
+	assert.Equal(t, 2, rejected[0].Row)
+	assert.Equal(t, mistypedFieldReason, rejected[0].Reason)
+	assert.Equal(t, 3, rejected[1].Row, "The rows after a mistyped one should keep their number")
+	assert.Equal(t, unknownRoleReason, rejected[1].Reason)
+}
+
+func TestShouldReturnErrorWhenSourceFormatIsNotSupported(t *testing.T) {
+	_, err := newBerthaService(berthaServiceConfig{authorsURL: "file://" + authorsBerthaOutput, rolesURL: "file://" + rolesBerthaOutput, sourceFormat: "xml"})
+	assert.NotNil(t, err)
+}
+
+func TestShouldReturnErrorWhenSourceURLIsNotSupported(t *testing.T) {
+	_, err := newBerthaService(berthaServiceConfig{authorsURL: "ftp://bertha.ig.ft.com/Authors", rolesURL: "file://" + rolesBerthaOutput})
+	assert.NotNil(t, err)
+}
+
 func TestShouldReturnErrorWhenBerthaAuthorsIsUnhappy(t *testing.T) {
 	berthaAuthorsMock.start("unhappy")
 	defer berthaAuthorsMock.stop()
 	berthaRolesMock.start("happy")
 	defer berthaRolesMock.stop()
 
-	bs, err := newBerthaService(berthaAuthorsMock.getUrl(), berthaRolesMock.getUrl())
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
 	assert.NotNil(t, err)
 
 	c := bs.getMembershipCount()
 	assert.Equal(t, 0, c, "It should return 0")
 
-	uuids := bs.getMembershipUuids()
+	uuids, _ := bs.getMembershipUuidsWithVersion()
 	assert.Equal(t, 0, len(uuids), "It should return 0 UUIDs")
 
 	m := bs.getMembershipByUuid(membership1.UUID)
@@ -163,7 +320,7 @@ func TestShouldReturnErrorWhenBerthaRolesIsUnhappy(t *testing.T) {
 	berthaRolesMock.start("unhappy")
 	defer berthaRolesMock.stop()
 
-	bs, err := newBerthaService(berthaAuthorsMock.getUrl(), berthaRolesMock.getUrl())
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
 	assert.NotNil(t, err)
 
 	err = bs.refreshMembershipCache()
@@ -172,18 +329,407 @@ func TestShouldReturnErrorWhenBerthaRolesIsUnhappy(t *testing.T) {
 	c := bs.getMembershipCount()
 	assert.Equal(t, 0, c, "It should return 0")
 
-	uuids := bs.getMembershipUuids()
+	uuids, _ := bs.getMembershipUuidsWithVersion()
 	assert.Equal(t, 0, len(uuids), "It should return 0 UUIDs")
 
 	m := bs.getMembershipByUuid(membership1.UUID)
 	assert.Equal(t, membership{}, m, "The membership should be empty")
 }
 
+func TestShouldKeepServingLastGoodMembershipsWhenRefreshFails(t *testing.T) {
+	berthaAuthorsMock.start("happy")
+	defer berthaAuthorsMock.stop()
+	berthaRolesMock.start("happy")
+	defer berthaRolesMock.stop()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
+	assert.Nil(t, err)
+	assert.Nil(t, bs.staleReason(), "Freshly loaded data should not be stale")
+
+	unhappyRolesMock := berthaMock{path: rolesBerthaPath}
+	unhappyRolesMock.start("unhappy")
+	defer unhappyRolesMock.stop()
+	bs.rolesSource = newBerthaSource(unhappyRolesMock.getUrl())
+
+	err = bs.refreshMembershipCache()
+	assert.NotNil(t, err)
+	assert.Equal(t, err, bs.staleReason(), "The failed refresh should be reported")
+
+	assert.Equal(t, 2, bs.getMembershipCount(), "The last good memberships should be served")
+	assert.Equal(t, membership1, bs.getMembershipByUuid(membership1.UUID), "The membership should be membership1")
+	assert.Equal(t, 2, bs.getPersonCount(), "The last good people should be served")
+	assert.Equal(t, 2, bs.getRoleCount(), "The last good roles should be served")
+
+	bs.rolesSource = newBerthaSource(berthaRolesMock.getUrl())
+	assert.Nil(t, bs.refreshMembershipCache())
+	assert.Nil(t, bs.staleReason(), "A successful refresh should clear the stale state")
+}
+
+func TestShouldNotReportStaleDataWhenNothingWasEverLoaded(t *testing.T) {
+	berthaAuthorsMock.start("unhappy")
+	defer berthaAuthorsMock.stop()
+	berthaRolesMock.start("happy")
+	defer berthaRolesMock.stop()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
+	assert.NotNil(t, err)
+	assert.Nil(t, bs.staleReason(), "There is no older data being served")
+}
+
+func TestShouldStartFromTheLatestSavedSnapshotWhenBerthaIsDown(t *testing.T) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	berthaAuthorsMock.start("happy")
+	defer berthaAuthorsMock.stop()
+	berthaRolesMock.start("happy")
+	defer berthaRolesMock.stop()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl(), snapshotDir: dir})
+	assert.Nil(t, err)
+	assert.Nil(t, bs.refreshMembershipCache())
+	files, _ := filepath.Glob(filepath.Join(dir, "snapshot-*.json"))
+	assert.Equal(t, 2, len(files), "Every successful refresh should be saved")
+
+	unhappyAuthorsMock := berthaMock{path: authorsBerthaPath}
+	unhappyAuthorsMock.start("unhappy")
+	defer unhappyAuthorsMock.stop()
+
+	warm, err := newBerthaService(berthaServiceConfig{authorsURL: unhappyAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl(), snapshotDir: dir})
+	assert.Nil(t, err, "The saved snapshot should be served while Bertha is down")
+	assert.Equal(t, bs.snapshotCreatedAt(), warm.snapshotCreatedAt(), "The snapshot should keep its creation time")
+	assert.Equal(t, 2, warm.getMembershipCount(), "The saved memberships should be served")
+	assert.Equal(t, membership1, warm.getMembershipByUuid(membership1.UUID), "The membership should be membership1")
+	assert.Equal(t, 2, warm.getPersonCount(), "The saved people should be served")
+	assert.Equal(t, 2, warm.getRoleCount(), "The saved roles should be served")
+	assert.False(t, warm.hasRefreshed(), "The initial loader should keep calling Bertha until a refresh succeeds")
+
+	warm.authorsSource = bs.authorsSource
+	assert.Nil(t, warm.refreshMembershipCache())
+	assert.True(t, warm.hasRefreshed())
+}
+
+func TestShouldRefuseRefreshThatDeletesTooManyMembershipsUnlessForced(t *testing.T) {
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: "file://" + authorsBerthaOutput, rolesURL: "file://" + rolesBerthaOutput, maxMembershipDropPercent: 25})
+	assert.Nil(t, err)
+	bs.authorsSource = &fileSource{path: "test-resources/authors-fixtures/01-wolf.json"}
+
+	err = bs.refreshMembershipCache()
+	assert.IsType(t, &massDeletionError{}, err)
+	assert.Equal(t, err, bs.checkMassDeletionGuard(), "The refusal should be reported")
+	assert.Equal(t, err, bs.staleReason(), "The previous memberships are served")
+	assert.Equal(t, 2, bs.getMembershipCount(), "The previous memberships should be served")
+
+	_, err = bs.reloadMembershipCache(true)
+	assert.Nil(t, err)
+	assert.Nil(t, bs.checkMassDeletionGuard(), "The forced refresh should clear the refusal")
+	assert.Equal(t, 1, bs.getMembershipCount(), "The forced refresh should be applied")
+}
+
+func TestShouldReportTheCountsOfTheSnapshotAReloadApplied(t *testing.T) {
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: "file://" + authorsBerthaOutput, rolesURL: "file://" + rolesBerthaOutput, maxMembershipDropPercent: 25})
+	assert.Nil(t, err)
+	bs.authorsSource = &fileSource{path: "test-resources/authors-fixtures/01-wolf.json"}
+
+	_, err = bs.reloadMembershipCache(false)
+	assert.IsType(t, &massDeletionError{}, err)
+
+	result, err := bs.reloadMembershipCache(true)
+	assert.Nil(t, err)
+	assert.Equal(t, refreshResult{memberships: 1, rejected: 0}, result)
+}
+
+func TestShouldPreviewRefreshWithoutApplyingIt(t *testing.T) {
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: "file://" + authorsBerthaOutput, rolesURL: "file://" + rolesBerthaOutput})
+	assert.Nil(t, err)
+	bs.authorsSource = &fileSource{path: "test-resources/authors-fixtures/01-wolf.json"}
+
+	diff, err := bs.previewMembershipRefresh()
+	assert.Nil(t, err)
+	assert.Empty(t, diff.Added)
+	assert.Empty(t, diff.Changed)
+	assert.Equal(t, 1, len(diff.Removed), "Lucy Kellaway is not in the new data")
+	assert.NotEqual(t, membership1.UUID, diff.Removed[0].UUID)
+	assert.Equal(t, 2, bs.getMembershipCount(), "Nothing should be applied")
+}
+
+func TestShouldKeepServingReadsWhileBerthaIsSlow(t *testing.T) {
+	requested := make(chan struct{})
+	release := make(chan struct{})
+	slowAuthorsMock := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(requested)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		http.ServeFile(w, r, authorsBerthaOutput)
+	}))
+	defer slowAuthorsMock.Close()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: "file://" + authorsBerthaOutput, rolesURL: "file://" + rolesBerthaOutput})
+	assert.Nil(t, err)
+	bs.authorsSource = newBerthaSource(slowAuthorsMock.URL + "/slow")
+
+	refreshed := make(chan error)
+	go func() {
+		refreshed <- bs.refreshMembershipCache()
+	}()
+	<-requested
+
+	reads := make(chan int)
+	go func() {
+		for i := 0; i < 100; i++ {
+			bs.getMembershipCount()
+			bs.getMembershipUuidsWithVersion()
+			bs.getMembershipByUuid(membership1.UUID)
+			bs.getPersonCount()
+			bs.getRoleCount()
+			bs.staleReason()
+		}
+		reads <- bs.getMembershipCount()
+	}()
+	select {
+	case c := <-reads:
+		assert.Equal(t, 2, c, "The served memberships should be read while Bertha is being fetched")
+	case <-time.After(time.Second):
+		t.Fatal("Reads should not wait for the refresh")
+	}
+
+	close(release)
+	assert.Nil(t, <-refreshed)
+	assert.Equal(t, 2, bs.getMembershipCount())
+}
+
+func TestShouldFetchAuthorsAndRolesAtTheSameTime(t *testing.T) {
+	var arrived sync.WaitGroup
+	arrived.Add(2)
+	// Each sheet is served only once both have been requested
+	barrier := func(outputFile string) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			arrived.Done()
+			arrived.Wait()
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("ETag", `"`+outputFile+`"`)
+			http.ServeFile(w, r, outputFile)
+		}))
+	}
+	authorsServer := barrier(authorsBerthaOutput)
+	defer authorsServer.Close()
+	rolesServer := barrier(rolesBerthaOutput)
+	defer rolesServer.Close()
+
+	loaded := make(chan error)
+	var bs *berthaService
+	go func() {
+		var err error
+		bs, err = newRefreshedBerthaService(berthaServiceConfig{authorsURL: authorsServer.URL + "/authors", rolesURL: rolesServer.URL + "/roles"})
+		loaded <- err
+	}()
+	select {
+	case err := <-loaded:
+		assert.Nil(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("The sheets should be fetched at the same time")
+	}
+
+	sources := bs.getCacheStatus().Sources
+	assert.Equal(t, authorsServer.URL+"/authors", sources.Authors.URL)
+	assert.Equal(t, `"`+authorsBerthaOutput+`"`, sources.Authors.ETag)
+	assert.NotEmpty(t, sources.Authors.LastModified)
+	assert.False(t, sources.Authors.FetchedAt.IsZero())
+	assert.Equal(t, `"`+rolesBerthaOutput+`"`, sources.Roles.ETag)
+}
+
+func TestShouldCancelTheOtherFetchWhenOneFails(t *testing.T) {
+	hangingAuthorsServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(10 * time.Second):
+		}
+	}))
+	defer hangingAuthorsServer.Close()
+	missingRolesServer := httptest.NewServer(http.NotFoundHandler())
+	defer missingRolesServer.Close()
+
+	start := time.Now()
+	_, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: hangingAuthorsServer.URL + "/authors", rolesURL: missingRolesServer.URL + "/roles"})
+	assert.IsType(t, &sourceError{}, err, "The failure of the roles should be reported, not the cancelled authors")
+	assert.Equal(t, unexpectedStatusReason, err.(*sourceError).reason)
+	assert.True(t, time.Since(start) < 5*time.Second, "The authors fetch should be cancelled")
+}
+
+func TestShouldFailRefreshWhenAnAuthorIsInvalid(t *testing.T) {
+	invalidAuthorsMock := berthaMock{outputFile: invalidAuthorsBerthaOutput, path: authorsBerthaPath}
+	invalidAuthorsMock.start("happy")
+	defer invalidAuthorsMock.stop()
+	berthaRolesMock.start("happy")
+	defer berthaRolesMock.stop()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: invalidAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
+	assert.EqualError(t, err, `Author at row 3 is invalid: Role UUID is not found for "Colunmist"`)
+	assert.Equal(t, 0, bs.getMembershipCount(), "It should return 0")
+}
+
+func TestShouldSkipInvalidAuthorsAndReportThem(t *testing.T) {
+	invalidAuthorsMock := berthaMock{outputFile: invalidAuthorsBerthaOutput, path: authorsBerthaPath}
+	invalidAuthorsMock.start("happy")
+	defer invalidAuthorsMock.stop()
+	berthaRolesMock.start("happy")
+	defer berthaRolesMock.stop()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: invalidAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl(), skipInvalidRecords: true})
+	assert.Nil(t, err)
+
+	assert.Equal(t, 1, bs.getMembershipCount(), "Only the valid author should be published")
+	assert.Equal(t, 1, bs.getPersonCount(), "Only the valid author should be published")
+	assert.Equal(t, membership1, bs.getMembershipByUuid(membership1.UUID), "The membership should be membership1")
+
+	rejected := bs.getRejectedRecords()
+	assert.Equal(t, 2, len(rejected), "The invalid authors should be reported")
+	assert.Equal(t, rejectedRecord{
+		Sheet:   authorsSheet,
+		Row:     3,
+		ID:      "Q0ItMDAwMDkyNg==-QXV0aG9ycw==",
+		Name:    "Lucy Kellaway",
+		Reason:  unknownRoleReason,
+		Message: `Role UUID is not found for "Colunmist"`,
+	}, rejected[0])
+	assert.Equal(t, 4, rejected[1].Row)
+	assert.Equal(t, missingTmeIdentifierReason, rejected[1].Reason)
+	assert.Equal(t, cacheCounts{Authors: 3, Roles: 2, Memberships: 1, Rejected: 2}, bs.getCacheStatus().Counts, "The rejected authors should be counted as read")
+}
+
+func TestShouldFailRefreshWhenRoleHierarchyHasACycle(t *testing.T) {
+	berthaAuthorsMock.start("happy")
+	defer berthaAuthorsMock.stop()
+	cyclicRolesMock := berthaMock{outputFile: cyclicRolesBerthaOutput, path: rolesBerthaPath}
+	cyclicRolesMock.start("happy")
+	defer cyclicRolesMock.stop()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: cyclicRolesMock.getUrl()})
+	assert.IsType(t, &roleGraphError{}, err)
+	assert.Equal(t, 2, len(err.(*roleGraphError).problems), "Both roles of the cycle should be reported")
+	assert.Equal(t, 0, bs.getMembershipCount(), "It should return 0")
+}
+
+func TestShouldQuarantineRolesInACycle(t *testing.T) {
+	berthaAuthorsMock.start("happy")
+	defer berthaAuthorsMock.stop()
+	cyclicRolesMock := berthaMock{outputFile: cyclicRolesBerthaOutput, path: rolesBerthaPath}
+	cyclicRolesMock.start("happy")
+	defer cyclicRolesMock.stop()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: cyclicRolesMock.getUrl(), skipInvalidRecords: true})
+	assert.Nil(t, err)
+
+	assert.Equal(t, []string{"9b1d1f65-4d3e-4c0e-8f1a-2f5e1a9e6c77"}, bs.getRoleUuids(), "Only the valid role should be published")
+	assert.Equal(t, 0, bs.getMembershipCount(), "Both authors are Columnists")
+
+	rejected := bs.getRejectedRecords()
+	assert.Equal(t, 4, len(rejected), "The quarantined roles and the authors using them should be reported")
+	assert.Equal(t, rolesSheet, rejected[0].Sheet)
+	assert.Equal(t, roleCycleReason, rejected[0].Reason)
+	assert.Equal(t, rolesSheet, rejected[1].Sheet)
+	assert.Equal(t, authorsSheet, rejected[2].Sheet)
+	assert.Equal(t, unknownRoleReason, rejected[2].Reason)
+}
+
+func TestShouldReportMembershipsChangedBetweenRefreshes(t *testing.T) {
+	berthaAuthorsMock.start("happy")
+	defer berthaAuthorsMock.stop()
+	berthaRolesMock.start("happy")
+	defer berthaRolesMock.stop()
+	invalidAuthorsMock := berthaMock{outputFile: invalidAuthorsBerthaOutput, path: authorsBerthaPath}
+	invalidAuthorsMock.start("happy")
+	defer invalidAuthorsMock.stop()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl(), skipInvalidRecords: true})
+	assert.Nil(t, err)
+
+	initial, err := bs.getMembershipChangesSince("")
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(initial.Created), "The initial memberships should be created")
+
+	assert.Nil(t, bs.refreshMembershipCache())
+	unchanged, err := bs.getMembershipChangesSince(initial.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, initial.Token, unchanged.Token, "Refreshing the same data should not change anything")
+
+	bs.authorsSource = newBerthaSource(invalidAuthorsMock.getUrl())
+	assert.Nil(t, bs.refreshMembershipCache())
+	changes, err := bs.getMembershipChangesSince(initial.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{membership2.UUID}, changes.Deleted, "The membership of the invalid author should be deleted")
+	assert.Empty(t, changes.Created)
+	assert.Empty(t, changes.Updated)
+}
+
+func TestShouldReportTheStatusOfTheCache(t *testing.T) {
+	berthaAuthorsMock.start("happy")
+	defer berthaAuthorsMock.stop()
+	berthaRolesMock.start("happy")
+	defer berthaRolesMock.stop()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
+	assert.Nil(t, err)
+
+	status := bs.getCacheStatus()
+	assert.NotNil(t, status.LastAttemptAt)
+	assert.NotEmpty(t, status.LastAttemptDuration)
+	assert.NotNil(t, status.LastSuccessAt)
+	assert.Equal(t, uint64(1), status.Version)
+	assert.Equal(t, berthaAuthorsMock.getUrl(), status.Sources.Authors.URL)
+	assert.Equal(t, berthaAuthorsMock.etag(), status.Sources.Authors.ETag)
+	assert.Equal(t, cacheCounts{Authors: 2, Roles: 2, Memberships: 2, Rejected: 0}, status.Counts)
+	assert.Empty(t, status.LastError)
+	assert.Len(t, status.ContentHash, 64)
+
+	assert.Nil(t, bs.refreshMembershipCache())
+	assert.Equal(t, status.ContentHash, bs.getCacheStatus().ContentHash, "The hash should only change with the memberships")
+}
+
+func TestShouldVersionSnapshotsByContentAcrossReplicas(t *testing.T) {
+	config := berthaServiceConfig{authorsURL: "file://" + authorsBerthaOutput, rolesURL: "file://" + rolesBerthaOutput}
+	replica1, err := newRefreshedBerthaService(config)
+	assert.Nil(t, err)
+	replica2, err := newRefreshedBerthaService(config)
+	assert.Nil(t, err)
+	config.authorsURL = "file://test-resources/authors-fixtures/01-wolf.json"
+	replica3, err := newRefreshedBerthaService(config)
+	assert.Nil(t, err)
+
+	assert.Equal(t, replica1.getCacheStatus().Version, replica3.getCacheStatus().Version, "Every replica should be at its first snapshot")
+	_, version1 := replica1.getMembershipCountWithVersion()
+	_, version2 := replica2.getMembershipUuidsWithVersion()
+	_, version3 := replica3.getMembershipCountWithVersion()
+	assert.Equal(t, version1, version2, "Replicas serving the same memberships should report the same version")
+	assert.NotEqual(t, version1, version3, "Replicas serving different memberships should report different versions")
+	assert.Equal(t, replica1.getCacheStatus().ContentHash, version1)
+}
+
+func TestShouldReportTheStatusOfTheCacheBeforeAnyDataIsLoaded(t *testing.T) {
+	berthaAuthorsMock.start("unhappy")
+	defer berthaAuthorsMock.stop()
+	berthaRolesMock.start("happy")
+	defer berthaRolesMock.stop()
+
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
+	assert.Error(t, err)
+
+	status := bs.getCacheStatus()
+	assert.NotNil(t, status.LastAttemptAt)
+	assert.Nil(t, status.LastSuccessAt)
+	assert.Equal(t, berthaAuthorsMock.getUrl(), status.Sources.Authors.URL, "The source URLs should be known before a load")
+	assert.Equal(t, berthaRolesMock.getUrl(), status.Sources.Roles.URL)
+	assert.Equal(t, err.Error(), status.LastError)
+	assert.Equal(t, unexpectedStatusReason, status.LastErrorReason)
+	assert.Empty(t, status.ContentHash)
+}
+
 func TestCheckConnectivityOfHappyBertaAuthors(t *testing.T) {
 	berthaAuthorsMock.start("happy")
 	defer berthaAuthorsMock.stop()
 
-	bs, err := newBerthaService(berthaAuthorsMock.getUrl(), berthaRolesMock.getUrl())
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
 	assert.NotNil(t, err)
 
 	c := bs.checkAuthorsConnectivity()
@@ -194,7 +740,7 @@ func TestCheckConnectivityOfUnhappyBerthaAuthors(t *testing.T) {
 	berthaAuthorsMock.start("unhappy")
 	defer berthaAuthorsMock.stop()
 
-	bs, err := newBerthaService(berthaAuthorsMock.getUrl(), berthaRolesMock.getUrl())
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
 	assert.NotNil(t, err)
 
 	c := bs.checkAuthorsConnectivity()
@@ -205,7 +751,7 @@ func TestCheckConnectivityOfHappyBertaRoles(t *testing.T) {
 	berthaRolesMock.start("happy")
 	defer berthaRolesMock.stop()
 
-	bs, err := newBerthaService(berthaAuthorsMock.getUrl(), berthaRolesMock.getUrl())
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
 	assert.NotNil(t, err)
 
 	c := bs.checkRolesConnectivity()
@@ -216,7 +762,7 @@ func TestCheckConnectivityOfUnhappyBerthaRoles(t *testing.T) {
 	berthaRolesMock.start("unhappy")
 	defer berthaRolesMock.stop()
 
-	bs, err := newBerthaService(berthaAuthorsMock.getUrl(), berthaRolesMock.getUrl())
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
 	assert.NotNil(t, err)
 	c := bs.checkRolesConnectivity()
 	assert.NotNil(t, c)
@@ -226,7 +772,7 @@ func TestCheckConnectivityBerthaAuthorsOffline(t *testing.T) {
 	berthaRolesMock.start("happy")
 	defer berthaRolesMock.stop()
 
-	bs, err := newBerthaService(berthaAuthorsMock.getUrl(), berthaRolesMock.getUrl())
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
 	assert.NotNil(t, err)
 
 	c := bs.checkAuthorsConnectivity()
@@ -237,7 +783,7 @@ func TestCheckConnectivityBerthaRolesOffline(t *testing.T) {
 	berthaAuthorsMock.start("happy")
 	defer berthaAuthorsMock.stop()
 
-	bs, err := newBerthaService(berthaAuthorsMock.getUrl(), berthaRolesMock.getUrl())
+	bs, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: berthaAuthorsMock.getUrl(), rolesURL: berthaRolesMock.getUrl()})
 	assert.NotNil(t, err)
 
 	c := bs.checkRolesConnectivity()
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type berthaSource struct {
+	url     string
+	breaker *circuitBreaker
+}
+
+func newBerthaSource(url string) *berthaSource {
+	return &berthaSource{url: url, breaker: client.newCircuitBreaker()}
+}
+
+func (s *berthaSource) read(ctx context.Context) (*sourceContent, error) {
+	resp, err := s.get(ctx, s.breaker)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, newSourceError(s, unexpectedStatusReason, "%s returned unexpected HTTP status %d", s.url, resp.StatusCode)
+	}
+	return &sourceContent{
+		body:         resp.Body,
+		contentType:  resp.Header.Get("Content-Type"),
+		etag:         resp.Header.Get("ETag"),
+		lastModified: resp.Header.Get("Last-Modified"),
+	}, nil
+}
+
+// The probe goes around the circuit breaker, so failed probes neither open it nor take the trial call of a refresh
+func (s *berthaSource) checkConnectivity() error {
+	resp, err := s.get(context.Background(), nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("Bertha returns unexpected HTTP status: %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func (s *berthaSource) get(ctx context.Context, breaker *circuitBreaker) (*http.Response, error) {
+	log.WithFields(log.Fields{"bertha_url": s.url}).Info("Calling Bertha...")
+	return client.get(ctx, s.url, breaker)
+}
+
+func (s *berthaSource) checkCircuitBreaker() error {
+	return s.breaker.check()
+}
+
+func (s *berthaSource) String() string {
+	return s.url
+}
@@ -2,24 +2,68 @@ package main
 
 import (
 	"fmt"
+	"regexp"
+	"strings"
+	"time"
 
 	"github.com/pborman/uuid"
 )
 
 const ftUUID = "dac01f07-4b6d-3615-8532-a56752cc7e5f"
 
+const (
+	tmeAuthority = "http://api.ft.com/system/FT-TME"
+	uppAuthority = "http://api.ft.com/system/FT-UPP"
+)
+
+var htmlTagRegexp = regexp.MustCompile(`<[^>]*>`)
+
+// Reasons why an author can't be transformed, reported with the rejected records
+const (
+	unknownRoleReason          = "unknownRole"
+	missingTmeIdentifierReason = "missingTmeIdentifier"
+	brokenParentChainReason    = "brokenParentChain"
+	invalidDateReason          = "invalidDate"
+)
+
+// Dates are read from the spreadsheet as plain dates or RFC3339 timestamps, and published in the UPP format
+const uppDateFormat = "2006-01-02T15:04:05.000Z"
+
+var sheetDateFormats = []string{"2006-01-02", time.RFC3339}
+
+type transformError struct {
+	reason string
+	msg    string
+}
+
+func newTransformError(reason string, format string, args ...interface{}) *transformError {
+	return &transformError{reason: reason, msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *transformError) Error() string {
+	return e.msg
+}
+
 type berthaTransformer struct {
 }
 
 func (bt *berthaTransformer) toMembership(a author, uuidRolesMap map[string]berthaRole, namesRolesMap map[string]berthaRole) (membership, error) {
+	if strings.TrimSpace(a.TmeIdentifier) == "" {
+		return membership{}, newTransformError(missingTmeIdentifierReason, `TME identifier is missing for author "%s"`, a.Name)
+	}
+
+	inceptionDate, terminationDate, err := toUPPDates(a.StartDate, a.EndDate)
+	if err != nil {
+		return membership{}, newTransformError(invalidDateReason, `Invalid dates for author "%s": %s`, a.Name, err.Error())
+	}
 
-	memRoles, err := bt.buildMembershipRoles(a.Role, uuidRolesMap, namesRolesMap)
+	memRoles, err := bt.buildMembershipRoles(a.Roles, uuidRolesMap, namesRolesMap)
 
 	if err != nil {
 		return membership{}, err
 	}
 
-	personUUID := uuid.NewMD5(uuid.UUID{}, []byte(a.TmeIdentifier)).String()
+	personUUID := toPersonUUID(a.TmeIdentifier)
 
 	membershipUUID := uuid.NewMD5(uuid.UUID{}, []byte(personUUID+"_MEMBER_"+ftUUID)).String()
 
@@ -34,32 +78,145 @@ func (bt *berthaTransformer) toMembership(a author, uuidRolesMap map[string]bert
 		OrganisationUUID:       ftUUID,
 		AlternativeIdentifiers: altIds,
 		MembershipRoles:        memRoles,
+		InceptionDate:          inceptionDate,
+		TerminationDate:        terminationDate,
 	}
 	return m, nil
 }
 
-func (bt *berthaTransformer) buildMembershipRoles(roleName string, uuidRolesMap map[string]berthaRole, nameRolesMap map[string]berthaRole) ([]membershipRole, error) {
-	berthaRole := nameRolesMap[roleName]
+func (bt *berthaTransformer) toPerson(a author) person {
+	personUUID := toPersonUUID(a.TmeIdentifier)
+
+	var aliases []string
+	if a.Name != "" {
+		aliases = []string{a.Name}
+	}
+
+	return person{
+		Uuid: personUUID,
+		Name: a.Name,
+		Identifiers: []identifier{
+			{Authority: tmeAuthority, IdentifierValue: a.TmeIdentifier},
+			{Authority: uppAuthority, IdentifierValue: personUUID},
+		},
+		Aliases:        aliases,
+		EmailAddress:   a.Email,
+		TwitterHandle:  a.TwitterHandle,
+		Description:    strings.TrimSpace(htmlTagRegexp.ReplaceAllString(a.Biography, "")),
+		DescriptionXML: a.Biography,
+		ImageUrl:       a.ImageUrl,
+	}
+}
+
+func (bt *berthaTransformer) toRole(br berthaRole) role {
+	var broaderUUIDs []string
+	if br.ParentUUID != "" {
+		broaderUUIDs = []string{br.ParentUUID}
+	}
+
+	return role{
+		UUID:                   br.UUID,
+		PrefLabel:              br.Preflabel,
+		Type:                   membershipRoleType,
+		BroaderUUIDs:           broaderUUIDs,
+		AlternativeIdentifiers: alternativeIdentifiers{UUIDS: []string{br.UUID}},
+	}
+}
+
+// The person UUID is an MD5 hash of the TME identifier, so memberships and people built from the same author agree
+func toPersonUUID(tmeIdentifier string) string {
+	return uuid.NewMD5(uuid.UUID{}, []byte(tmeIdentifier)).String()
+}
+
+// Every role of the author is expanded with its ancestors, which share the dates of the role.
+// A role reached more than once is listed only the first time.
+func (bt *berthaTransformer) buildMembershipRoles(roles []authorRole, uuidRolesMap map[string]berthaRole, nameRolesMap map[string]berthaRole) ([]membershipRole, error) {
+	if len(roles) == 0 {
+		return []membershipRole{}, newTransformError(unknownRoleReason, "No role is given")
+	}
+
 	memRoles := []membershipRole{}
+	added := make(map[string]bool)
+	for _, r := range roles {
+		hierarchy, err := bt.buildRoleHierarchy(r.Name, uuidRolesMap, nameRolesMap)
+		if err != nil {
+			return []membershipRole{}, err
+		}
+		inceptionDate, terminationDate, err := toUPPDates(r.StartDate, r.EndDate)
+		if err != nil {
+			return []membershipRole{}, newTransformError(invalidDateReason, `Invalid dates for role "%s": %s`, r.Name, err.Error())
+		}
+		for _, roleUUID := range hierarchy {
+			if !added[roleUUID] {
+				added[roleUUID] = true
+				memRoles = append(memRoles, membershipRole{
+					RoleUUID:        roleUUID,
+					InceptionDate:   inceptionDate,
+					TerminationDate: terminationDate,
+				})
+			}
+		}
+	}
+	return memRoles, nil
+}
+
+// Returns the UUIDs of the named role and of its ancestors, closest first
+func (bt *berthaTransformer) buildRoleHierarchy(roleName string, uuidRolesMap map[string]berthaRole, nameRolesMap map[string]berthaRole) ([]string, error) {
+	berthaRole := nameRolesMap[roleName]
 	if berthaRole.UUID == "" {
-		return []membershipRole{}, fmt.Errorf(`Role UUID is not found for "%s"`, berthaRole.Preflabel)
+		return nil, newTransformError(unknownRoleReason, `Role UUID is not found for "%s"`, roleName)
 	}
 
+	var hierarchy []string
+	visited := make(map[string]bool)
 	for parentRoleUUID := berthaRole.UUID; parentRoleUUID != ""; {
+		childRole := berthaRole
 		berthaRole = uuidRolesMap[parentRoleUUID]
-		memRole, err := bt.transformRole(berthaRole)
-		if err != nil {
-			return []membershipRole{}, err
+		if berthaRole.UUID == "" {
+			return nil, newTransformError(brokenParentChainReason, `Parent role "%s" of "%s" is not found`, parentRoleUUID, childRole.Preflabel)
+		}
+		if visited[berthaRole.UUID] {
+			return nil, newTransformError(brokenParentChainReason, `Role "%s" is its own ancestor`, berthaRole.Preflabel)
 		}
-		memRoles = append(memRoles, memRole)
+		visited[berthaRole.UUID] = true
+		hierarchy = append(hierarchy, berthaRole.UUID)
 		parentRoleUUID = berthaRole.ParentUUID
 	}
-	return memRoles, nil
+	return hierarchy, nil
+}
+
+// Both dates are optional, but when both are given the end can't be before the start
+func toUPPDates(startDate string, endDate string) (string, string, error) {
+	start, err := parseSheetDate("start", startDate)
+	if err != nil {
+		return "", "", err
+	}
+	end, err := parseSheetDate("end", endDate)
+	if err != nil {
+		return "", "", err
+	}
+	if !start.IsZero() && !end.IsZero() && end.Before(start) {
+		return "", "", fmt.Errorf(`end date "%s" is before start date "%s"`, endDate, startDate)
+	}
+	return formatUPPDate(start), formatUPPDate(end), nil
+}
+
+func parseSheetDate(name string, value string) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range sheetDateFormats {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf(`%s date "%s" is not a valid date, expected YYYY-MM-DD`, name, value)
 }
 
-func (bt *berthaTransformer) transformRole(br berthaRole) (membershipRole, error) {
-	if br.UUID == "" {
-		return membershipRole{}, fmt.Errorf(`Role UUID is not found for "%s"`, br.Preflabel)
+func formatUPPDate(t time.Time) string {
+	if t.IsZero() {
+		return ""
 	}
-	return membershipRole{RoleUUID: br.UUID}, nil
+	return t.Format(uppDateFormat)
 }
@@ -20,3 +20,136 @@ func TestShouldReturnErrorWhenRoleIsNotFound(t *testing.T) {
 	_, err := transformer.toMembership(anotherAuthor, aUUIDRolesMap, aNameRolesMap)
 	assert.NotNil(t, err)
 }
+
+func TestShouldReturnUnknownRoleReasonWhenRoleIsNotFound(t *testing.T) {
+	transformer := berthaTransformer{}
+	a := anAuthor
+	a.Roles = authorRoles{{Name: anotherRoleLabel}}
+	_, err := transformer.toMembership(a, aUUIDRolesMap, aNameRolesMap)
+	assert.Equal(t, newTransformError(unknownRoleReason, `Role UUID is not found for "Rockstar"`), err)
+}
+
+func TestShouldReturnMissingTmeIdentifierReasonWhenTmeIdentifierIsEmpty(t *testing.T) {
+	transformer := berthaTransformer{}
+	a := anAuthor
+	a.TmeIdentifier = " "
+	_, err := transformer.toMembership(a, aUUIDRolesMap, aNameRolesMap)
+	assert.Equal(t, missingTmeIdentifierReason, err.(*transformError).reason)
+}
+
+func TestShouldReturnBrokenParentChainReasonWhenParentRoleIsNotFound(t *testing.T) {
+	transformer := berthaTransformer{}
+	orphanRole := berthaRole{UUID: aRoleUUID, Preflabel: aRoleLabel, ParentUUID: "8d2a1c4c-5c1f-4a4e-9b38-2d0f7b8a8e11"}
+	_, err := transformer.toMembership(anAuthor,
+		map[string]berthaRole{orphanRole.UUID: orphanRole},
+		map[string]berthaRole{orphanRole.Preflabel: orphanRole})
+	assert.Equal(t, newTransformError(brokenParentChainReason, `Parent role "8d2a1c4c-5c1f-4a4e-9b38-2d0f7b8a8e11" of "Superhero" is not found`), err)
+}
+
+func TestShouldTransformAuthorToPersonWithSameUUIDAsMembershipPerson(t *testing.T) {
+	transformer := berthaTransformer{}
+	p := transformer.toPerson(anAuthor)
+	assert.Equal(t, expectedPerson, p, "The person is transformed properly")
+
+	m, err := transformer.toMembership(anAuthor, aUUIDRolesMap, aNameRolesMap)
+	assert.Nil(t, err)
+	assert.Equal(t, m.PersonUUID, p.Uuid, "The membership should point at the transformed person")
+}
+
+func TestShouldTransformBerthaRoleToRoleWithBroaderParent(t *testing.T) {
+	transformer := berthaTransformer{}
+	assert.Equal(t, expectedRole, transformer.toRole(aBerthaRole), "The role is transformed properly")
+
+	topRole := transformer.toRole(anotherBerthaRole)
+	assert.Nil(t, topRole.BroaderUUIDs, "A role without parent should not have broader roles")
+}
+
+func TestShouldNotLoopForeverWhenRoleIsItsOwnAncestor(t *testing.T) {
+	transformer := berthaTransformer{}
+	child := berthaRole{UUID: aRoleUUID, Preflabel: aRoleLabel, ParentUUID: yetAnotherRoleUUID}
+	parent := berthaRole{UUID: yetAnotherRoleUUID, Preflabel: "Hero", ParentUUID: aRoleUUID}
+	_, err := transformer.toMembership(anAuthor,
+		map[string]berthaRole{child.UUID: child, parent.UUID: parent},
+		map[string]berthaRole{child.Preflabel: child, parent.Preflabel: parent})
+	assert.Equal(t, brokenParentChainReason, err.(*transformError).reason)
+}
+
+func TestShouldExpandEveryRoleOfTheAuthorWithoutDuplicates(t *testing.T) {
+	transformer := berthaTransformer{}
+	editor := berthaRole{UUID: "0d9d3b21-cf5c-4c5e-9b2a-6a29f61b7b56", Preflabel: "Editor", ParentUUID: aRoleUUID}
+	villain := berthaRole{UUID: "c1b5e6a4-2f0d-4a49-b2f2-3d3f4c1c9b8e", Preflabel: "Villain"}
+	uuidRolesMap := map[string]berthaRole{aBerthaRole.UUID: aBerthaRole, anotherBerthaRole.UUID: anotherBerthaRole, editor.UUID: editor, villain.UUID: villain}
+	nameRolesMap := map[string]berthaRole{aBerthaRole.Preflabel: aBerthaRole, anotherBerthaRole.Preflabel: anotherBerthaRole, editor.Preflabel: editor, villain.Preflabel: villain}
+
+	a := anAuthor
+	a.Roles = authorRoles{{Name: "Hero"}, {Name: "Editor"}, {Name: "Superhero"}, {Name: "Villain"}}
+	m, err := transformer.toMembership(a, uuidRolesMap, nameRolesMap)
+	assert.Nil(t, err)
+	assert.Equal(t, []membershipRole{
+		{RoleUUID: yetAnotherRoleUUID},
+		{RoleUUID: editor.UUID},
+		{RoleUUID: aRoleUUID},
+		{RoleUUID: villain.UUID},
+	}, m.MembershipRoles, "Overlapping hierarchies should be listed once")
+}
+
+func TestShouldKeepACommaInTheRoleCellAsPartOfThePreflabel(t *testing.T) {
+	transformer := berthaTransformer{}
+	weekend := anotherBerthaRoleWithLabel("Editor, Weekend")
+	uuidRolesMap := map[string]berthaRole{weekend.UUID: weekend}
+	nameRolesMap := map[string]berthaRole{weekend.Preflabel: weekend}
+
+	a := anAuthor
+	a.Roles = parseRoleCell("Editor, Weekend")
+	m, err := transformer.toMembership(a, uuidRolesMap, nameRolesMap)
+	assert.Nil(t, err)
+	assert.Equal(t, []membershipRole{{RoleUUID: weekend.UUID}}, m.MembershipRoles)
+}
+
+func TestShouldReturnErrorWhenAnyRoleOfTheAuthorIsNotFound(t *testing.T) {
+	transformer := berthaTransformer{}
+	a := anAuthor
+	a.Roles = authorRoles{{Name: aRoleLabel}, {Name: anotherRoleLabel}}
+	_, err := transformer.toMembership(a, aUUIDRolesMap, aNameRolesMap)
+	assert.Equal(t, newTransformError(unknownRoleReason, `Role UUID is not found for "Rockstar"`), err)
+}
+
+func TestShouldReturnErrorWhenAuthorHasNoRole(t *testing.T) {
+	transformer := berthaTransformer{}
+	a := anAuthor
+	a.Roles = authorRoles{}
+	_, err := transformer.toMembership(a, aUUIDRolesMap, aNameRolesMap)
+	assert.Equal(t, unknownRoleReason, err.(*transformError).reason)
+}
+
+func TestShouldTransformAuthorAndRoleDates(t *testing.T) {
+	transformer := berthaTransformer{}
+	a := anAuthor
+	a.StartDate = "2008-05-01"
+	a.EndDate = "2016-03-31T17:30:00Z"
+	a.Roles = authorRoles{{Name: aRoleLabel, StartDate: "2010-01-01"}}
+	m, err := transformer.toMembership(a, aUUIDRolesMap, aNameRolesMap)
+	assert.Nil(t, err)
+	assert.Equal(t, "2008-05-01T00:00:00.000Z", m.InceptionDate)
+	assert.Equal(t, "2016-03-31T17:30:00.000Z", m.TerminationDate)
+	assert.Equal(t, []membershipRole{
+		{RoleUUID: aRoleUUID, InceptionDate: "2010-01-01T00:00:00.000Z"},
+		{RoleUUID: yetAnotherRoleUUID, InceptionDate: "2010-01-01T00:00:00.000Z"},
+	}, m.MembershipRoles, "The ancestors should share the dates of the role")
+}
+
+func TestShouldReturnInvalidDateReasonWhenDateIsMalformed(t *testing.T) {
+	transformer := berthaTransformer{}
+	a := anAuthor
+	a.StartDate = "31/12/2015"
+	_, err := transformer.toMembership(a, aUUIDRolesMap, aNameRolesMap)
+	assert.Equal(t, newTransformError(invalidDateReason, `Invalid dates for author "Tony Stark": start date "31/12/2015" is not a valid date, expected YYYY-MM-DD`), err)
+}
+
+func TestShouldReturnInvalidDateReasonWhenEndDateIsBeforeStartDate(t *testing.T) {
+	transformer := berthaTransformer{}
+	a := anAuthor
+	a.Roles = authorRoles{{Name: aRoleLabel, StartDate: "2015-01-01", EndDate: "2014-12-31"}}
+	_, err := transformer.toMembership(a, aUUIDRolesMap, aNameRolesMap)
+	assert.Equal(t, newTransformError(invalidDateReason, `Invalid dates for role "Superhero": end date "2014-12-31" is before start date "2015-01-01"`), err)
+}
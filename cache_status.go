@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"time"
+)
+
+// When the cache was refreshed, what it holds and why the last refresh failed, so on-call doesn't need the logs
+type cacheStatus struct {
+	LastAttemptAt       *time.Time  `json:"lastAttemptAt,omitempty"`
+	LastAttemptDuration string      `json:"lastAttemptDuration,omitempty"`
+	LastSuccessAt       *time.Time  `json:"lastSuccessAt,omitempty"`
+	Version             uint64      `json:"version"`
+	Sources             sourcePair  `json:"sources"`
+	Counts              cacheCounts `json:"counts"`
+	LastError           string      `json:"lastError,omitempty"`
+	LastErrorReason     string      `json:"lastErrorReason,omitempty"`
+	ContentHash         string      `json:"contentHash,omitempty"`
+}
+
+// Authors are the records read from the sheet, so the rejected authors are also in the rejected count
+type cacheCounts struct {
+	Authors     int `json:"authors"`
+	Roles       int `json:"roles"`
+	Memberships int `json:"memberships"`
+	Rejected    int `json:"rejected"`
+}
+
+// A hash of every membership in UUID order, equal for two snapshots serving the same memberships
+func membershipsHash(membershipHashes map[string]string) string {
+	h := sha256.New()
+	for _, uuid := range sortedKeys(membershipHashes) {
+		h.Write([]byte(uuid))
+		h.Write([]byte(membershipHashes[uuid]))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
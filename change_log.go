@@ -0,0 +1,159 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	createdChange = "created"
+	updatedChange = "updated"
+	deletedChange = "deleted"
+)
+
+const maxChangeLogEntries = 10000
+
+var errInvalidChangeToken = errors.New("Invalid change token")
+
+// The token is older than the oldest change kept, or comes from another instance of the service.
+// The caller needs to start again from the full list of ids.
+var errExpiredChangeToken = errors.New("Change token has expired, the full list of ids needs to be read again")
+
+type changeEntry struct {
+	sequence uint64
+	uuid     string
+	kind     string
+}
+
+type changeSet struct {
+	Since   string   `json:"since"`
+	Token   string   `json:"token"`
+	Created []string `json:"created"`
+	Updated []string `json:"updated"`
+	Deleted []string `json:"deleted"`
+}
+
+// Records the memberships created, updated and deleted by every refresh, in a monotonically increasing sequence.
+// Tokens carry the epoch of the log, so tokens of an earlier run or of another replica are never mixed up with this one.
+type changeLog struct {
+	epoch      int64
+	sequence   uint64
+	entries    []changeEntry
+	maxEntries int
+}
+
+func newChangeLog() *changeLog {
+	return &changeLog{epoch: time.Now().UnixNano(), maxEntries: maxChangeLogEntries}
+}
+
+func (cl *changeLog) record(previous map[string]string, current map[string]string) {
+	for _, uuid := range sortedKeys(current) {
+		if previousHash, found := previous[uuid]; !found {
+			cl.append(uuid, createdChange)
+		} else if previousHash != current[uuid] {
+			cl.append(uuid, updatedChange)
+		}
+	}
+	for _, uuid := range sortedKeys(previous) {
+		if _, found := current[uuid]; !found {
+			cl.append(uuid, deletedChange)
+		}
+	}
+	if overflow := len(cl.entries) - cl.maxEntries; overflow > 0 {
+		cl.entries = append([]changeEntry(nil), cl.entries[overflow:]...)
+	}
+}
+
+func (cl *changeLog) append(uuid string, kind string) {
+	cl.sequence++
+	cl.entries = append(cl.entries, changeEntry{sequence: cl.sequence, uuid: uuid, kind: kind})
+}
+
+func (cl *changeLog) token(sequence uint64) string {
+	return fmt.Sprintf("%d.%d", cl.epoch, sequence)
+}
+
+// Returns the net changes after the given token, so a membership created and then deleted is not listed at all
+func (cl *changeLog) changesSince(token string) (changeSet, error) {
+	since, err := cl.parseToken(token)
+	if err != nil {
+		return changeSet{}, err
+	}
+	if since > cl.sequence {
+		return changeSet{}, errInvalidChangeToken
+	}
+	if len(cl.entries) > 0 && since+1 < cl.entries[0].sequence {
+		return changeSet{}, errExpiredChangeToken
+	}
+
+	first := make(map[string]string)
+	last := make(map[string]string)
+	for _, e := range cl.entries {
+		if e.sequence <= since {
+			continue
+		}
+		if _, found := first[e.uuid]; !found {
+			first[e.uuid] = e.kind
+		}
+		last[e.uuid] = e.kind
+	}
+
+	cs := changeSet{Since: cl.token(since), Token: cl.token(cl.sequence), Created: []string{}, Updated: []string{}, Deleted: []string{}}
+	for _, uuid := range sortedKeys(last) {
+		switch {
+		case last[uuid] == deletedChange && first[uuid] == createdChange:
+		case last[uuid] == deletedChange:
+			cs.Deleted = append(cs.Deleted, uuid)
+		case first[uuid] == createdChange:
+			cs.Created = append(cs.Created, uuid)
+		default:
+			cs.Updated = append(cs.Updated, uuid)
+		}
+	}
+	return cs, nil
+}
+
+// An empty token means the start of the log
+func (cl *changeLog) parseToken(token string) (uint64, error) {
+	if token == "" {
+		return 0, nil
+	}
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return 0, errInvalidChangeToken
+	}
+	epoch, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, errInvalidChangeToken
+	}
+	sequence, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, errInvalidChangeToken
+	}
+	if epoch != cl.epoch {
+		return 0, errExpiredChangeToken
+	}
+	return sequence, nil
+}
+
+func contentHash(v interface{}) string {
+	data, _ := json.Marshal(v)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
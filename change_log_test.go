@@ -0,0 +1,68 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldRecordCreatedUpdatedAndDeletedMemberships(t *testing.T) {
+	cl := newChangeLog()
+	cl.record(map[string]string{}, map[string]string{"a": "1", "b": "1", "c": "1"})
+	first, err := cl.changesSince("")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"a", "b", "c"}, first.Created)
+
+	cl.record(map[string]string{"a": "1", "b": "1", "c": "1"}, map[string]string{"a": "1", "b": "2", "d": "1"})
+	cs, err := cl.changesSince(first.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, changeSet{
+		Since:   first.Token,
+		Token:   cl.token(6),
+		Created: []string{"d"},
+		Updated: []string{"b"},
+		Deleted: []string{"c"},
+	}, cs)
+
+	empty, err := cl.changesSince(cs.Token)
+	assert.Nil(t, err)
+	assert.Equal(t, cs.Token, empty.Token, "The token should not move without changes")
+	assert.Empty(t, empty.Created)
+	assert.Empty(t, empty.Updated)
+	assert.Empty(t, empty.Deleted)
+}
+
+func TestShouldReturnNetChangesSinceToken(t *testing.T) {
+	cl := newChangeLog()
+	cl.record(map[string]string{}, map[string]string{"a": "1", "b": "1"})
+	start := cl.token(cl.sequence)
+	cl.record(map[string]string{"a": "1", "b": "1"}, map[string]string{"b": "2", "c": "1"})
+	cl.record(map[string]string{"b": "2", "c": "1"}, map[string]string{"a": "2", "b": "3"})
+
+	cs, err := cl.changesSince(start)
+	assert.Nil(t, err)
+	assert.Empty(t, cs.Created, "c was created and deleted again")
+	assert.Equal(t, []string{"a", "b"}, cs.Updated, "a was deleted and created again, b was updated twice")
+	assert.Empty(t, cs.Deleted)
+}
+
+func TestShouldRejectExpiredAndInvalidTokens(t *testing.T) {
+	cl := newChangeLog()
+	cl.maxEntries = 2
+	cl.record(map[string]string{}, map[string]string{"a": "1", "b": "1", "c": "1"})
+
+	_, err := cl.changesSince("")
+	assert.Equal(t, errExpiredChangeToken, err, "The first change is not kept anymore")
+
+	_, err = cl.changesSince(cl.token(1))
+	assert.Nil(t, err, "The changes after the first one are kept")
+
+	_, err = newChangeLog().changesSince(cl.token(1))
+	assert.Equal(t, errExpiredChangeToken, err, "Tokens of another change log have expired")
+
+	_, err = cl.changesSince(cl.token(4))
+	assert.Equal(t, errInvalidChangeToken, err, "Tokens from the future are invalid")
+
+	_, err = cl.changesSince("not-a-token")
+	assert.Equal(t, errInvalidChangeToken, err)
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	circuitClosed   = "closed"
+	circuitOpen     = "open"
+	circuitHalfOpen = "half-open"
+)
+
+type circuitOpenError struct {
+	failures int
+	until    time.Time
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("Circuit breaker is open after %d consecutive failures, Bertha is not called until %s", e.failures, e.until.Format(time.RFC3339))
+}
+
+// Stops calling Bertha after a number of consecutive failures. Once the cooldown is over a single trial call
+// is let through: its success closes the circuit again, its failure opens it for another cooldown.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	now       func() time.Time
+	mutex     *sync.Mutex
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		state:     circuitClosed,
+		now:       time.Now,
+		mutex:     &sync.Mutex{},
+	}
+}
+
+// Returns an error instead of letting the call through while the circuit is open.
+// A nil breaker lets every call through and counts nothing.
+func (cb *circuitBreaker) allow() error {
+	if cb == nil {
+		return nil
+	}
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	switch cb.state {
+	case circuitOpen:
+		if cb.now().Before(cb.openedAt.Add(cb.cooldown)) {
+			return cb.openError()
+		}
+		cb.state = circuitHalfOpen
+		return nil
+	case circuitHalfOpen:
+		// The trial call is still running
+		return cb.openError()
+	default:
+		return nil
+	}
+}
+
+func (cb *circuitBreaker) success() {
+	if cb == nil {
+		return
+	}
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.state = circuitClosed
+	cb.failures = 0
+}
+
+func (cb *circuitBreaker) failure() {
+	if cb == nil {
+		return
+	}
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	cb.failures++
+	if cb.state == circuitHalfOpen || cb.failures >= cb.threshold {
+		cb.state = circuitOpen
+		cb.openedAt = cb.now()
+	}
+}
+
+// A call cancelled by the caller tells nothing about Bertha, but ends a trial call
+func (cb *circuitBreaker) cancelled() {
+	if cb == nil {
+		return
+	}
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.state == circuitHalfOpen {
+		cb.state = circuitOpen
+	}
+}
+
+// Reports an open circuit without counting as a call
+func (cb *circuitBreaker) check() error {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	if cb.state == circuitOpen && cb.now().Before(cb.openedAt.Add(cb.cooldown)) {
+		return cb.openError()
+	}
+	return nil
+}
+
+func (cb *circuitBreaker) currentState() string {
+	cb.mutex.Lock()
+	defer cb.mutex.Unlock()
+	return cb.state
+}
+
+func (cb *circuitBreaker) openError() error {
+	return &circuitOpenError{failures: cb.failures, until: cb.openedAt.Add(cb.cooldown)}
+}
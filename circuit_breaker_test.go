@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldOpenCircuitAfterConsecutiveFailures(t *testing.T) {
+	cb := newCircuitBreaker(3, time.Minute)
+	cb.failure()
+	cb.failure()
+	cb.success()
+	cb.failure()
+	cb.failure()
+	assert.Equal(t, circuitClosed, cb.currentState(), "A success should reset the failures")
+	assert.Nil(t, cb.allow())
+
+	cb.failure()
+	assert.Equal(t, circuitOpen, cb.currentState())
+	assert.NotNil(t, cb.allow())
+	assert.NotNil(t, cb.check())
+}
+
+func TestShouldLetASingleTrialCallThroughAfterTheCooldown(t *testing.T) {
+	now := time.Date(2017, 6, 1, 10, 0, 0, 0, time.UTC)
+	cb := newCircuitBreaker(1, time.Minute)
+	cb.now = func() time.Time { return now }
+	cb.failure()
+
+	now = now.Add(time.Minute)
+	assert.Nil(t, cb.check(), "The cooldown is over")
+	assert.Nil(t, cb.allow(), "The trial call should be let through")
+	assert.Equal(t, circuitHalfOpen, cb.currentState())
+	assert.NotNil(t, cb.allow(), "Only one trial call should be let through")
+
+	cb.failure()
+	assert.Equal(t, circuitOpen, cb.currentState(), "A failed trial should open the circuit again")
+	assert.EqualError(t, cb.allow(), "Circuit breaker is open after 2 consecutive failures, Bertha is not called until 2017-06-01T10:02:00Z")
+
+	now = now.Add(time.Minute)
+	assert.Nil(t, cb.allow())
+	cb.success()
+	assert.Equal(t, circuitClosed, cb.currentState(), "A successful trial should close the circuit")
+}
+
+func TestShouldNotCountCancelledCalls(t *testing.T) {
+	cb := newCircuitBreaker(1, 0)
+	assert.Nil(t, cb.allow())
+	cb.cancelled()
+	assert.Equal(t, circuitClosed, cb.currentState())
+
+	cb.failure()
+	assert.Nil(t, cb.allow(), "The cooldown is over")
+	cb.cancelled()
+	assert.Equal(t, circuitOpen, cb.currentState(), "A cancelled trial should leave the circuit open")
+	assert.Nil(t, cb.allow(), "Another trial should be let through")
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// A sheet checked in as a single JSON file, or a CSV file when its extension is .csv
+type fileSource struct {
+	path string
+}
+
+func (s *fileSource) read(ctx context.Context) (*sourceContent, error) {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	contentType := jsonContentType
+	if strings.ToLower(filepath.Ext(s.path)) == ".csv" {
+		contentType = csvContentType
+	}
+	return &sourceContent{body: f, contentType: contentType, lastModified: info.ModTime().UTC().Format(http.TimeFormat)}, nil
+}
+
+func (s *fileSource) checkConnectivity() error {
+	f, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+func (s *fileSource) String() string {
+	return "file://" + s.path
+}
+
+// A sheet split into several JSON fixture files, each holding an array of records.
+// The arrays are concatenated in the lexical order of the file names.
+type dirSource struct {
+	path string
+}
+
+// The directory is as recent as its most recently modified fixture
+func (s *dirSource) read(ctx context.Context) (*sourceContent, error) {
+	files, err := s.files()
+	if err != nil {
+		return nil, err
+	}
+
+	records := []json.RawMessage{}
+	var lastModified time.Time
+	for _, f := range files {
+		if info, err := os.Stat(f); err == nil && info.ModTime().After(lastModified) {
+			lastModified = info.ModTime()
+		}
+		data, err := ioutil.ReadFile(f)
+		if err != nil {
+			return nil, err
+		}
+		var fileRecords []json.RawMessage
+		if err := json.Unmarshal(data, &fileRecords); err != nil {
+			return nil, fmt.Errorf("Invalid fixture %s: %v", f, err)
+		}
+		records = append(records, fileRecords...)
+	}
+
+	data, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+	content := &sourceContent{body: ioutil.NopCloser(bytes.NewReader(data)), contentType: jsonContentType}
+	if !lastModified.IsZero() {
+		content.lastModified = lastModified.UTC().Format(http.TimeFormat)
+	}
+	return content, nil
+}
+
+func (s *dirSource) checkConnectivity() error {
+	files, err := s.files()
+	if err != nil {
+		return err
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("No JSON fixture found in %s", s.path)
+	}
+	return nil
+}
+
+func (s *dirSource) files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(s.path, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func (s *dirSource) String() string {
+	return "file://" + s.path
+}
@@ -22,13 +22,20 @@ var anotherAuthorTmeIdentifier = "Q0ItMDAwMDkyNg==-QXV0aG9ycw=="
 var expectedAnotherAuthorUUID = "8f9ac45f-2cc2-35f7-83f4-579c66a09eb0"
 
 var anAuthor = author{
-	Role:          aRoleLabel,
+	Name:          "Tony Stark",
+	Roles:         authorRoles{{Name: aRoleLabel}},
 	Jobtitle:      aJobTitle,
+	Email:         "tony.stark@ft.com",
+	ImageUrl:      "https://www.ft.com/__origami/service/image/v2/images/raw/fthead:tony-stark?source=next",
+	Biography:     "<p>Tony Stark is a <strong>genius</strong> billionaire.</p>",
+	TwitterHandle: "@tonystark",
 	TmeIdentifier: anAuthorTmeIdentifier,
+	StartDate:     "2008-05-01",
+	EndDate:       "2016-03-31T17:30:00Z",
 }
 
 var anotherAuthor = author{
-	Role:          anotherRoleLabel,
+	Roles:         authorRoles{{Name: anotherRoleLabel}},
 	Jobtitle:      aJobTitle,
 	TmeIdentifier: "",
 }
@@ -54,4 +61,29 @@ var expectedMembership = membership{
 	OrganisationUUID:       ftUUID,
 	AlternativeIdentifiers: alternativeIdentifiers{UUIDS: []string{expectedMembershipUUID}},
 	MembershipRoles:        []membershipRole{membershipRole{RoleUUID: aRoleUUID}, membershipRole{RoleUUID: yetAnotherRoleUUID}},
+	InceptionDate:          "2008-05-01T00:00:00.000Z",
+	TerminationDate:        "2016-03-31T17:30:00.000Z",
+}
+
+var expectedPerson = person{
+	Uuid: expectedAuthorUUID,
+	Name: "Tony Stark",
+	Identifiers: []identifier{
+		{Authority: tmeAuthority, IdentifierValue: anAuthorTmeIdentifier},
+		{Authority: uppAuthority, IdentifierValue: expectedAuthorUUID},
+	},
+	Aliases:        []string{"Tony Stark"},
+	EmailAddress:   "tony.stark@ft.com",
+	TwitterHandle:  "@tonystark",
+	Description:    "Tony Stark is a genius billionaire.",
+	DescriptionXML: "<p>Tony Stark is a <strong>genius</strong> billionaire.</p>",
+	ImageUrl:       "https://www.ft.com/__origami/service/image/v2/images/raw/fthead:tony-stark?source=next",
+}
+
+var expectedRole = role{
+	UUID:                   aRoleUUID,
+	PrefLabel:              aRoleLabel,
+	Type:                   membershipRoleType,
+	BroaderUUIDs:           []string{yetAnotherRoleUUID},
+	AlternativeIdentifiers: alternativeIdentifiers{UUIDS: []string{aRoleUUID}},
 }
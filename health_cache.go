@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+)
+
+const defaultHealthCheckInterval = time.Minute
+
+var errNotCheckedYet = errors.New("Not checked yet")
+
+type cachedCheckResult struct {
+	message   string
+	err       error
+	checkedAt time.Time
+}
+
+// Runs the health checks on a ticker, so that /__health answers from the last results
+// instead of calling Bertha on every probe
+type healthCache struct {
+	checks   []fthealth.Check
+	interval time.Duration
+	results  []cachedCheckResult
+	mutex    *sync.RWMutex
+	stopped  chan struct{}
+	once     *sync.Once
+}
+
+func newHealthCache(checks []fthealth.Check, interval time.Duration) *healthCache {
+	results := make([]cachedCheckResult, len(checks))
+	for i := range results {
+		results[i] = cachedCheckResult{message: errNotCheckedYet.Error(), err: errNotCheckedYet}
+	}
+	return &healthCache{
+		checks:   checks,
+		interval: interval,
+		results:  results,
+		mutex:    &sync.RWMutex{},
+		stopped:  make(chan struct{}),
+		once:     &sync.Once{},
+	}
+}
+
+// The checks are run once straight away, then on every tick until stopped
+func (hc *healthCache) start() {
+	go func() {
+		ticker := time.NewTicker(hc.interval)
+		defer ticker.Stop()
+		for {
+			hc.runChecks()
+			select {
+			case <-ticker.C:
+			case <-hc.stopped:
+				return
+			}
+		}
+	}()
+}
+
+func (hc *healthCache) stop() {
+	hc.once.Do(func() {
+		close(hc.stopped)
+	})
+}
+
+// The checks run in parallel, so a slow Bertha sheet doesn't delay the results of the other checks
+func (hc *healthCache) runChecks() {
+	var wg sync.WaitGroup
+	for i, check := range hc.checks {
+		wg.Add(1)
+		go func(i int, check fthealth.Check) {
+			defer wg.Done()
+			message, err := check.Checker()
+			hc.mutex.Lock()
+			hc.results[i] = cachedCheckResult{message: message, err: err, checkedAt: time.Now()}
+			hc.mutex.Unlock()
+		}(i, check)
+	}
+	wg.Wait()
+}
+
+// Copies of the checks that return the last results, with the time they were checked
+func (hc *healthCache) cachedChecks() []fthealth.Check {
+	checks := make([]fthealth.Check, len(hc.checks))
+	for i, check := range hc.checks {
+		checks[i] = check
+		checks[i].Checker = hc.cachedChecker(i)
+	}
+	return checks
+}
+
+func (hc *healthCache) cachedChecker(i int) func() (string, error) {
+	return func() (string, error) {
+		hc.mutex.RLock()
+		result := hc.results[i]
+		hc.mutex.RUnlock()
+		if result.checkedAt.IsZero() {
+			return result.message, result.err
+		}
+		checkedAt := result.checkedAt.UTC().Format(time.RFC3339)
+		if result.err != nil {
+			return result.message, fmt.Errorf("%s (checked at %s)", result.err.Error(), checkedAt)
+		}
+		return fmt.Sprintf("%s (checked at %s)", result.message, checkedAt), nil
+	}
+}
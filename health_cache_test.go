@@ -0,0 +1,63 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHealthCacheShouldReportNotCheckedYetBeforeTheFirstRun(t *testing.T) {
+	hc := newHealthCache([]fthealth.Check{{Name: "Bertha", Checker: func() (string, error) { return "ok", nil }}}, time.Minute)
+
+	_, err := hc.cachedChecks()[0].Checker()
+	assert.EqualError(t, err, "Not checked yet")
+}
+
+func TestHealthCacheShouldReturnTheLastResultsWithTheirTime(t *testing.T) {
+	var calls int32
+	hc := newHealthCache([]fthealth.Check{
+		{Name: "Healthy", Checker: func() (string, error) {
+			atomic.AddInt32(&calls, 1)
+			return "Bertha is ok", nil
+		}},
+		{Name: "Unhealthy", Checker: func() (string, error) { return "Bertha is down", errors.New("Exterminate!") }},
+	}, time.Minute)
+	hc.runChecks()
+
+	checks := hc.cachedChecks()
+	assert.Equal(t, "Healthy", checks[0].Name)
+	msg, err := checks[0].Checker()
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(msg, "Bertha is ok (checked at "), msg)
+	msg, err = checks[0].Checker()
+	assert.Nil(t, err)
+	assert.Equal(t, int32(1), atomic.LoadInt32(&calls), "Reading the results should not run the check again")
+
+	_, err = checks[1].Checker()
+	assert.Error(t, err)
+	assert.True(t, strings.HasPrefix(err.Error(), "Exterminate! (checked at "), err.Error())
+}
+
+func TestHealthCacheShouldRunTheChecksOnEveryTickUntilStopped(t *testing.T) {
+	ran := make(chan struct{}, 10)
+	hc := newHealthCache([]fthealth.Check{{Name: "Bertha", Checker: func() (string, error) {
+		ran <- struct{}{}
+		return "ok", nil
+	}}}, 10*time.Millisecond)
+	hc.start()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-ran:
+		case <-time.After(time.Second):
+			t.Fatal("The checks were not run on the ticker")
+		}
+	}
+	hc.stop()
+	hc.stop()
+}
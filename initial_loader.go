@@ -0,0 +1,81 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type dataLoader interface {
+	cacheRefresher
+	hasRefreshed() bool
+}
+
+// Runs the first refresh in the background and retries it until one succeeds, doubling the wait after every failure
+// up to a maximum, so the service can start while Bertha is down without calling it in a tight loop.
+// A snapshot read back from disk doesn't count, it is served while the refresh is retried.
+type initialLoader struct {
+	loader   dataLoader
+	minDelay time.Duration
+	maxDelay time.Duration
+	quit     chan struct{}
+	done     sync.WaitGroup
+}
+
+func newInitialLoader(loader dataLoader, minDelay time.Duration, maxDelay time.Duration) *initialLoader {
+	return &initialLoader{
+		loader:   loader,
+		minDelay: minDelay,
+		maxDelay: maxDelay,
+		quit:     make(chan struct{}),
+	}
+}
+
+func (il *initialLoader) start() {
+	log.WithFields(log.Fields{"minDelay": il.minDelay, "maxDelay": il.maxDelay}).Info("Loading the data in the background")
+	il.done.Add(1)
+	go il.run()
+}
+
+func (il *initialLoader) stop() {
+	close(il.quit)
+	il.done.Wait()
+}
+
+func (il *initialLoader) run() {
+	defer il.done.Done()
+	// The first attempt is made straight away
+	var delay time.Duration
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	for {
+		select {
+		case <-il.quit:
+			return
+		case <-timer.C:
+			// A reload or a scheduled refresh may have succeeded in the meantime
+			if il.loader.hasRefreshed() {
+				return
+			}
+			if err := il.loader.refreshMembershipCache(); err == nil {
+				log.Info("Initial load succeeded")
+				return
+			}
+			delay = il.nextDelay(delay)
+			log.WithField("retryIn", delay).Warn("Initial load failed")
+			timer.Reset(delay)
+		}
+	}
+}
+
+func (il *initialLoader) nextDelay(delay time.Duration) time.Duration {
+	delay *= 2
+	if delay < il.minDelay {
+		return il.minDelay
+	}
+	if delay > il.maxDelay {
+		return il.maxDelay
+	}
+	return delay
+}
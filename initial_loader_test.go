@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Fails the given number of refreshes, then succeeds
+type flakyLoader struct {
+	failures  int
+	refreshes chan time.Time
+	refreshed bool
+}
+
+func (fl *flakyLoader) refreshMembershipCache() error {
+	fl.refreshes <- time.Now()
+	if fl.failures > 0 {
+		fl.failures--
+		return errors.New("Bertha is down")
+	}
+	fl.refreshed = true
+	return nil
+}
+
+func (fl *flakyLoader) hasRefreshed() bool {
+	return fl.refreshed
+}
+
+func TestShouldRetryInitialLoadUntilItSucceeds(t *testing.T) {
+	loader := &flakyLoader{failures: 2, refreshes: make(chan time.Time, 10)}
+	il := newInitialLoader(loader, 5*time.Millisecond, time.Second)
+	il.start()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-loader.refreshes:
+		case <-time.After(time.Second):
+			t.Fatalf("Attempt %d did not happen", i+1)
+		}
+	}
+	il.done.Wait()
+
+	select {
+	case <-loader.refreshes:
+		t.Fatal("No attempt should happen once a refresh succeeded")
+	case <-time.After(30 * time.Millisecond):
+	}
+}
+
+func TestShouldNotRetryWhenARefreshSucceededMeanwhile(t *testing.T) {
+	loader := &flakyLoader{refreshes: make(chan time.Time, 10), refreshed: true}
+	il := newInitialLoader(loader, time.Millisecond, time.Second)
+	il.start()
+	il.done.Wait()
+
+	assert.Empty(t, loader.refreshes, "A refresh already succeeded")
+}
+
+func TestShouldDoubleRetryDelayUpToTheMaximum(t *testing.T) {
+	il := newInitialLoader(nil, time.Second, 5*time.Second)
+	assert.Equal(t, time.Second, il.nextDelay(0), "The first retry should wait the minimum")
+	assert.Equal(t, 2*time.Second, il.nextDelay(time.Second))
+	assert.Equal(t, 4*time.Second, il.nextDelay(2*time.Second))
+	assert.Equal(t, 5*time.Second, il.nextDelay(4*time.Second))
+	assert.Equal(t, 5*time.Second, il.nextDelay(5*time.Second))
+}
@@ -0,0 +1,35 @@
+package main
+
+import "fmt"
+
+const massDeletionReason = "massDeletion"
+
+type massDeletionError struct {
+	previous int
+	current  int
+}
+
+func (e *massDeletionError) Error() string {
+	return fmt.Sprintf("Refresh refused, memberships would drop from %d to %d. Reload with force=true to accept the new data", e.previous, e.current)
+}
+
+// Refuses a refresh that drops more memberships than allowed, in percent of the served ones or in number,
+// as when the authors sheet has been filtered or cleared by mistake. A zero limit is not checked.
+type massDeletionGuard struct {
+	maxDropPercent int
+	maxDrop        int
+}
+
+func (g massDeletionGuard) check(previous int, current int) error {
+	drop := previous - current
+	if drop <= 0 {
+		return nil
+	}
+	if g.maxDrop > 0 && drop > g.maxDrop {
+		return &massDeletionError{previous: previous, current: current}
+	}
+	if g.maxDropPercent > 0 && drop*100 > g.maxDropPercent*previous {
+		return &massDeletionError{previous: previous, current: current}
+	}
+	return nil
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldRefuseDropAbovePercentage(t *testing.T) {
+	g := massDeletionGuard{maxDropPercent: 25}
+	assert.Nil(t, g.check(100, 75))
+	assert.Nil(t, g.check(100, 120), "More memberships are never refused")
+	assert.EqualError(t, g.check(100, 74), "Refresh refused, memberships would drop from 100 to 74. Reload with force=true to accept the new data")
+}
+
+func TestShouldRefuseDropAboveNumber(t *testing.T) {
+	g := massDeletionGuard{maxDrop: 10}
+	assert.Nil(t, g.check(1000, 990))
+	assert.NotNil(t, g.check(1000, 989))
+}
+
+func TestShouldNotCheckZeroLimits(t *testing.T) {
+	assert.Nil(t, massDeletionGuard{}.check(500, 0))
+}
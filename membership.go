@@ -7,6 +7,8 @@ type membership struct {
 	OrganisationUUID       string                 `json:"organisationUuid"`
 	AlternativeIdentifiers alternativeIdentifiers `json:"alternativeIdentifiers"`
 	MembershipRoles        []membershipRole       `json:"membershipRoles"`
+	InceptionDate          string                 `json:"inceptionDate,omitempty"`
+	TerminationDate        string                 `json:"terminationDate,omitempty"`
 }
 
 type alternativeIdentifiers struct {
@@ -14,5 +16,7 @@ type alternativeIdentifiers struct {
 }
 
 type membershipRole struct {
-	RoleUUID string `json:"roleUuid,omitempty"`
+	RoleUUID        string `json:"roleUuid,omitempty"`
+	InceptionDate   string `json:"inceptionDate,omitempty"`
+	TerminationDate string `json:"terminationDate,omitempty"`
 }
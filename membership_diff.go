@@ -0,0 +1,84 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"sort"
+)
+
+// A field of a membership that differs between the served and the new data.
+// Old is missing for an added membership, New for a removed one.
+type fieldChange struct {
+	Field string      `json:"field"`
+	Old   interface{} `json:"old,omitempty"`
+	New   interface{} `json:"new,omitempty"`
+}
+
+type membershipChange struct {
+	UUID   string        `json:"uuid"`
+	Fields []fieldChange `json:"fields"`
+}
+
+type membershipDiff struct {
+	Added   []membershipChange `json:"added"`
+	Removed []membershipChange `json:"removed"`
+	Changed []membershipChange `json:"changed"`
+}
+
+// Compares the memberships field by field, using their JSON names
+func diffMemberships(previous map[string]membership, current map[string]membership) membershipDiff {
+	diff := membershipDiff{Added: []membershipChange{}, Removed: []membershipChange{}, Changed: []membershipChange{}}
+	for _, uuid := range sortedMembershipUUIDs(current) {
+		p, found := previous[uuid]
+		if !found {
+			diff.Added = append(diff.Added, membershipChange{UUID: uuid, Fields: diffFields(nil, jsonFields(current[uuid]))})
+		} else if fields := diffFields(jsonFields(p), jsonFields(current[uuid])); len(fields) > 0 {
+			diff.Changed = append(diff.Changed, membershipChange{UUID: uuid, Fields: fields})
+		}
+	}
+	for _, uuid := range sortedMembershipUUIDs(previous) {
+		if _, found := current[uuid]; !found {
+			diff.Removed = append(diff.Removed, membershipChange{UUID: uuid, Fields: diffFields(jsonFields(previous[uuid]), nil)})
+		}
+	}
+	return diff
+}
+
+func diffFields(previous map[string]interface{}, current map[string]interface{}) []fieldChange {
+	names := make(map[string]bool)
+	for name := range previous {
+		names[name] = true
+	}
+	for name := range current {
+		names[name] = true
+	}
+	sorted := make([]string, 0, len(names))
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	changes := []fieldChange{}
+	for _, name := range sorted {
+		if !reflect.DeepEqual(previous[name], current[name]) {
+			changes = append(changes, fieldChange{Field: name, Old: previous[name], New: current[name]})
+		}
+	}
+	return changes
+}
+
+func jsonFields(m membership) map[string]interface{} {
+	var fields map[string]interface{}
+	data, _ := json.Marshal(m)
+	json.Unmarshal(data, &fields)
+	return fields
+}
+
+func sortedMembershipUUIDs(memberships map[string]membership) []string {
+	uuids := make([]string, 0, len(memberships))
+	for uuid := range memberships {
+		uuids = append(uuids, uuid)
+	}
+	sort.Strings(uuids)
+	return uuids
+}
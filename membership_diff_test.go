@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldDiffMembershipsFieldByField(t *testing.T) {
+	promoted := membership1
+	promoted.PrefLabel = "Chief Economics Editor"
+	promoted.InceptionDate = "2017-01-01T00:00:00.000Z"
+
+	diff := diffMemberships(
+		map[string]membership{membership1.UUID: membership1, membership2.UUID: membership2},
+		map[string]membership{membership1.UUID: promoted, "e06be0f8-0426-4ee8-80e3-3da37255818a": {UUID: "e06be0f8-0426-4ee8-80e3-3da37255818a", PersonUUID: expectedAuthorUUID}},
+	)
+
+	assert.Equal(t, []membershipChange{{UUID: membership1.UUID, Fields: []fieldChange{
+		{Field: "inceptionDate", Old: "2008-05-01T00:00:00.000Z", New: "2017-01-01T00:00:00.000Z"},
+		{Field: "prefLabel", Old: "Chief Economics Commentator", New: "Chief Economics Editor"},
+	}}}, diff.Changed)
+
+	assert.Equal(t, 1, len(diff.Added))
+	assert.Equal(t, "e06be0f8-0426-4ee8-80e3-3da37255818a", diff.Added[0].UUID)
+	assert.Contains(t, diff.Added[0].Fields, fieldChange{Field: "personUuid", New: expectedAuthorUUID})
+
+	assert.Equal(t, 1, len(diff.Removed))
+	assert.Equal(t, membership2.UUID, diff.Removed[0].UUID)
+	assert.Contains(t, diff.Removed[0].Fields, fieldChange{Field: "uuid", Old: membership2.UUID})
+}
+
+func TestShouldFindNoDifferenceBetweenTheSameMemberships(t *testing.T) {
+	memberships := map[string]membership{membership1.UUID: membership1}
+	diff := diffMemberships(memberships, memberships)
+	assert.Empty(t, diff.Added)
+	assert.Empty(t, diff.Removed)
+	assert.Empty(t, diff.Changed)
+}
@@ -3,9 +3,13 @@ package main
 import (
 	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"reflect"
+	"strconv"
+	"strings"
+	"time"
 
 	fthealth "github.com/Financial-Times/go-fthealth/v1_1"
 	"github.com/Financial-Times/service-status-go/gtg"
@@ -13,56 +17,173 @@ import (
 	log "github.com/sirupsen/logrus"
 )
 
+const (
+	dataNotLoadedMsg        = "Data is not loaded yet"
+	dataNotLoadedRetryAfter = 30 * time.Second
+)
+
+const reloadJobsPath = "/transformers/memberships/__reload/"
+
+// Every health check links to its own section of the runbook
+const panicGuide = "https://dewey.in.ft.com/view/system/curated-authors-memberships-tf"
+
+func panicGuideFor(anchor string) string {
+	return panicGuide + "#" + anchor
+}
+
+// Limits of the freshness and data-quality health checks
+type healthThresholds struct {
+	maxRefreshAge  time.Duration
+	minMemberships int
+}
+
+var defaultHealthThresholds = healthThresholds{
+	maxRefreshAge:  24 * time.Hour,
+	minMemberships: 1,
+}
+
 type membershipHandler struct {
 	membershipService membershipService
+	reloadJobs        *reloadJobs
+	refreshOnCount    bool
+	thresholds        healthThresholds
 }
 
-func newMembershipHandler(ms membershipService) membershipHandler {
+func newMembershipHandler(ms membershipService, refreshOnCount bool, thresholds healthThresholds) membershipHandler {
 	return membershipHandler{
 		membershipService: ms,
+		reloadJobs:        newReloadJobs(ms),
+		refreshOnCount:    refreshOnCount,
+		thresholds:        thresholds,
 	}
 }
 
 func (mh *membershipHandler) refreshMembershipCache(writer http.ResponseWriter, req *http.Request) {
-	err := mh.membershipService.refreshMembershipCache()
-	if err != nil {
-		writeJSONMessage(writer, err.Error(), http.StatusInternalServerError)
+	if req.URL.Query().Get("dryRun") == "true" {
+		mh.previewMembershipRefresh(writer)
+		return
+	}
+	job := mh.reloadJobs.submit(req.URL.Query().Get("force") == "true")
+	if req.URL.Query().Get("async") == "true" {
+		writer.Header().Set("Location", reloadJobsPath+job.ID)
+		writeJSONObject(writer, job, http.StatusAccepted)
+		return
+	}
+
+	job, _ = mh.reloadJobs.wait(job.ID)
+	if err := job.err; err != nil {
+		if mh.membershipService.staleReason() != nil {
+			writeStaleWarning(writer, err)
+			writeRefreshError(writer, "Refresh failed, serving memberships from the last successful refresh: "+err.Error(), err)
+			return
+		}
+		writeRefreshError(writer, err.Error(), err)
 	} else {
 		writeJSONMessage(writer, "Memberships fetched", http.StatusOK)
 	}
 }
 
-func (mh *membershipHandler) getMembershipsCount(writer http.ResponseWriter, req *http.Request) {
-	err := mh.membershipService.refreshMembershipCache()
+func (mh *membershipHandler) getReloadJob(writer http.ResponseWriter, req *http.Request) {
+	job, found := mh.reloadJobs.get(mux.Vars(req)["id"])
+	writeJSONResponse(job, found, "Reload job not found", writer)
+}
+
+// Nothing is applied, the diff tells which memberships the refresh would add, remove and change
+func (mh *membershipHandler) previewMembershipRefresh(writer http.ResponseWriter) {
+	diff, err := mh.membershipService.previewMembershipRefresh()
 	if err != nil {
-		writeJSONMessage(writer, err.Error(), http.StatusInternalServerError)
-	} else {
-		c := mh.membershipService.getMembershipCount()
-		var buffer bytes.Buffer
-		buffer.WriteString(fmt.Sprintf(`%v`, c))
-		buffer.WriteTo(writer)
+		writeRefreshError(writer, err.Error(), err)
+		return
+	}
+	writeJSONResponse(diff, true, "", writer)
+}
+
+// The count comes from the served snapshot, unless the caller asks for a refresh first with refresh=true
+// or the service is configured to refresh on every count
+func (mh *membershipHandler) getMembershipsCount(writer http.ResponseWriter, req *http.Request) {
+	if mh.refreshOnCount || req.URL.Query().Get("refresh") == "true" {
+		// The refresh is coalesced with the reloads, and without any data loaded its failure is reported below as unavailable
+		job := mh.reloadJobs.submit(false)
+		job, _ = mh.reloadJobs.wait(job.ID)
+		if err := job.err; err != nil && mh.membershipService.isLoaded() {
+			if mh.membershipService.staleReason() != nil {
+				writeStaleWarning(writer, err)
+				writeRefreshError(writer, "Refresh failed, serving memberships from the last successful refresh: "+err.Error(), err)
+				return
+			}
+			writeRefreshError(writer, err.Error(), err)
+			return
+		}
+	}
+	if !requireLoadedData(writer, mh.membershipService) {
+		return
 	}
+	writeCacheHeaders(writer, mh.membershipService)
+	c, version := mh.membershipService.getMembershipCountWithVersion()
+	writeSnapshotVersion(writer, version)
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf(`%v`, c))
+	buffer.WriteTo(writer)
 }
 
 func (mh *membershipHandler) getMembershipUuids(writer http.ResponseWriter, req *http.Request) {
-	uuids := mh.membershipService.getMembershipUuids()
+	if !requireLoadedData(writer, mh.membershipService) {
+		return
+	}
+	writeCacheHeaders(writer, mh.membershipService)
+	uuids, version := mh.membershipService.getMembershipUuidsWithVersion()
+	writeSnapshotVersion(writer, version)
 	writeStreamResponse(uuids, writer)
 }
 
 func (mh *membershipHandler) getMembershipByUuid(writer http.ResponseWriter, req *http.Request) {
 	vars := mux.Vars(req)
 	uuid := vars["uuid"]
+	if !requireLoadedData(writer, mh.membershipService) {
+		return
+	}
+	writeCacheHeaders(writer, mh.membershipService)
 	m := mh.membershipService.getMembershipByUuid(uuid)
-	writeJSONResponse(m, !reflect.DeepEqual(m, membership{}), writer)
+	writeJSONResponse(m, !reflect.DeepEqual(m, membership{}), "Membership not found", writer)
+}
+
+func (mh *membershipHandler) getRejectedRecords(writer http.ResponseWriter, req *http.Request) {
+	if !requireLoadedData(writer, mh.membershipService) {
+		return
+	}
+	writeCacheHeaders(writer, mh.membershipService)
+	rejected := mh.membershipService.getRejectedRecords()
+	writeJSONResponse(rejected, true, "", writer)
+}
+
+// Answered before data is loaded too, to tell why the first refresh failed
+func (mh *membershipHandler) getCacheStatus(writer http.ResponseWriter, req *http.Request) {
+	writeJSONObject(writer, mh.membershipService.getCacheStatus(), http.StatusOK)
+}
+
+func (mh *membershipHandler) getMembershipChanges(writer http.ResponseWriter, req *http.Request) {
+	if !requireLoadedData(writer, mh.membershipService) {
+		return
+	}
+	changes, err := mh.membershipService.getMembershipChangesSince(req.URL.Query().Get("since"))
+	switch err {
+	case nil:
+		writeCacheHeaders(writer, mh.membershipService)
+		writeJSONResponse(changes, true, "", writer)
+	case errExpiredChangeToken:
+		writeJSONMessage(writer, err.Error(), http.StatusGone)
+	default:
+		writeJSONMessage(writer, err.Error(), http.StatusBadRequest)
+	}
 }
 
 func (mh *membershipHandler) AuthorsHealthCheck() fthealth.Check {
 	return fthealth.Check{
-		BusinessImpact:   "Unable to respond to request for curated author data from Bertha",
+		BusinessImpact:   "Changes to the curated authors in Bertha are not picked up, the memberships of the last successful refresh are served",
 		Name:             "Check connectivity to Bertha Authors Spreadsheet",
-		PanicGuide:       "https://dewey.in.ft.com/view/system/curated-authors-memberships-tf",
+		PanicGuide:       panicGuideFor("bertha-authors-connectivity"),
 		Severity:         1,
-		TechnicalSummary: "Cannot connect to Bertha to be able to supply curated authors information",
+		TechnicalSummary: "Cannot connect to the Bertha authors sheet. Check that the sheet URL is published and that Bertha is up",
 		Checker:          mh.authorsChecker,
 	}
 }
@@ -77,11 +198,11 @@ func (mh *membershipHandler) authorsChecker() (string, error) {
 
 func (mh *membershipHandler) RolesHealthCheck() fthealth.Check {
 	return fthealth.Check{
-		BusinessImpact:   "Unable to respond to request for curated author roles data from Bertha",
+		BusinessImpact:   "Changes to the author roles in Bertha are not picked up, the memberships of the last successful refresh are served",
 		Name:             "Check connectivity to Bertha Roles Spreadsheet",
-		PanicGuide:       "https://dewey.in.ft.com/view/system/curated-authors-memberships-tf",
+		PanicGuide:       panicGuideFor("bertha-roles-connectivity"),
 		Severity:         1,
-		TechnicalSummary: "Cannot connect to Bertha to be able to supply author roles",
+		TechnicalSummary: "Cannot connect to the Bertha roles sheet. Check that the sheet URL is published and that Bertha is up",
 		Checker:          mh.rolesChecker,
 	}
 }
@@ -94,30 +215,131 @@ func (mh *membershipHandler) rolesChecker() (string, error) {
 	return "Error connecting to Bertha Authors", err
 }
 
-func (mh *membershipHandler) GTG() gtg.Status {
-	rolesStatusCheck := func() gtg.Status {
-		return gtgCheck(mh.rolesChecker)
+func (mh *membershipHandler) CircuitBreakerHealthCheck() fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "Curated author memberships are not refreshed from Bertha until the circuit breaker closes",
+		Name:             "Check the circuit breakers of the Bertha sources",
+		PanicGuide:       panicGuideFor("circuit-breakers"),
+		Severity:         2,
+		TechnicalSummary: "Calls to Bertha failed repeatedly, so Bertha is not called until the cooldown is over",
+		Checker:          mh.circuitBreakerChecker,
+	}
+}
+
+func (mh *membershipHandler) circuitBreakerChecker() (string, error) {
+	err := mh.membershipService.checkCircuitBreakers()
+	if err == nil {
+		return "Circuit breakers of Bertha Authors and Roles are closed", err
+	}
+	return "Circuit breaker of Bertha is open", err
+}
+
+func (mh *membershipHandler) MassDeletionHealthCheck() fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "Spreadsheet changes are not published, the memberships from before the refused refresh are served",
+		Name:             "Check for refreshes refused by the mass deletion guard",
+		PanicGuide:       panicGuideFor("mass-deletion-guard"),
+		Severity:         1,
+		TechnicalSummary: "The authors sheet lost more memberships than allowed. Check the sheet, then reload with force=true if the deletions are wanted",
+		Checker:          mh.massDeletionChecker,
+	}
+}
+
+func (mh *membershipHandler) massDeletionChecker() (string, error) {
+	err := mh.membershipService.checkMassDeletionGuard()
+	if err == nil {
+		return "No refresh was refused by the mass deletion guard", err
+	}
+	return "Refresh was refused by the mass deletion guard", err
+}
+
+func (mh *membershipHandler) SnapshotHealthCheck() fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "Curated author memberships may be out of date, or not available at all",
+		Name:             "Check the age of the served memberships snapshot",
+		PanicGuide:       panicGuideFor("snapshot-age"),
+		Severity:         2,
+		TechnicalSummary: "No snapshot of the Bertha data has been loaded, or the served one is older than the maximum refresh age. Check the last refresh error, then reload",
+		Checker:          mh.snapshotChecker,
+	}
+}
+
+func (mh *membershipHandler) snapshotChecker() (string, error) {
+	createdAt := mh.membershipService.snapshotCreatedAt()
+	if createdAt.IsZero() {
+		return "No snapshot is loaded", errors.New("No snapshot is loaded")
+	}
+	age := time.Since(createdAt)
+	age -= age % time.Second
+	if mh.thresholds.maxRefreshAge > 0 && age > mh.thresholds.maxRefreshAge {
+		return "Served snapshot is too old", fmt.Errorf("Served snapshot was created at %s, %s ago, more than %s", createdAt.Format(time.RFC3339), age, mh.thresholds.maxRefreshAge)
+	}
+	return fmt.Sprintf("Serving snapshot created at %s, %s ago", createdAt.Format(time.RFC3339), age), nil
+}
+
+func (mh *membershipHandler) LastRefreshHealthCheck() fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "Curated author memberships may be out of date",
+		Name:             "Check the result of the last refresh",
+		PanicGuide:       panicGuideFor("last-refresh"),
+		Severity:         3,
+		TechnicalSummary: "The last refresh from Bertha failed, the memberships of the last successful refresh are served. The error gives the failing source and reason",
+		Checker:          mh.lastRefreshChecker,
+	}
+}
+
+func (mh *membershipHandler) lastRefreshChecker() (string, error) {
+	err := mh.membershipService.lastRefreshError()
+	if err == nil {
+		return "Last refresh succeeded", nil
+	}
+	return "Last refresh failed", err
+}
+
+func (mh *membershipHandler) MembershipCountHealthCheck() fthealth.Check {
+	return fthealth.Check{
+		BusinessImpact:   "Curated author memberships are missing from UPP",
+		Name:             "Check the number of served memberships",
+		PanicGuide:       panicGuideFor("membership-count"),
+		Severity:         1,
+		TechnicalSummary: "Fewer memberships than the minimum are served. Check the authors sheet in Bertha and the rejected records",
+		Checker:          mh.membershipCountChecker,
 	}
+}
 
-	authorsStatusCheck := func() gtg.Status {
-		return gtgCheck(mh.authorsChecker)
+func (mh *membershipHandler) membershipCountChecker() (string, error) {
+	count := mh.membershipService.getMembershipCount()
+	if count == 0 || count < mh.thresholds.minMemberships {
+		return "Too few memberships are served", fmt.Errorf("%d memberships are served, the minimum is %d", count, mh.thresholds.minMemberships)
 	}
+	return fmt.Sprintf("%d memberships are served", count), nil
+}
 
-	return gtg.FailFastParallelCheck([]gtg.StatusChecker{rolesStatusCheck, authorsStatusCheck})()
+func (mh *membershipHandler) healthChecks() []fthealth.Check {
+	return []fthealth.Check{
+		mh.AuthorsHealthCheck(),
+		mh.RolesHealthCheck(),
+		mh.CircuitBreakerHealthCheck(),
+		mh.MassDeletionHealthCheck(),
+		mh.SnapshotHealthCheck(),
+		mh.LastRefreshHealthCheck(),
+		mh.MembershipCountHealthCheck(),
+	}
 }
 
-func gtgCheck(handler func() (string, error)) gtg.Status {
-	if _, err := handler(); err != nil {
-		return gtg.Status{GoodToGo: false, Message: err.Error()}
+// Good to go once data is loaded. Bertha is not called, the loaded data is served while Bertha is unreachable.
+func (mh *membershipHandler) GTG() gtg.Status {
+	if !mh.membershipService.isLoaded() {
+		return gtg.Status{GoodToGo: false, Message: dataNotLoadedMsg}
 	}
 	return gtg.Status{GoodToGo: true}
 }
 
-func writeJSONResponse(obj interface{}, found bool, writer http.ResponseWriter) {
+func writeJSONResponse(obj interface{}, found bool, notFoundMsg string, writer http.ResponseWriter) {
 	writer.Header().Add("Content-Type", "application/json")
 
 	if !found {
-		writeJSONMessage(writer, "Membership not found", http.StatusNotFound)
+		writeJSONMessage(writer, notFoundMsg, http.StatusNotFound)
 		return
 	}
 
@@ -129,10 +351,65 @@ func writeJSONResponse(obj interface{}, found bool, writer http.ResponseWriter)
 	}
 }
 
-func writeJSONMessage(w http.ResponseWriter, errorMsg string, statusCode int) {
+// Until the first refresh succeeds there is no data to serve, and the caller is told when to try again
+func requireLoadedData(w http.ResponseWriter, reporter staleDataReporter) bool {
+	if reporter.isLoaded() {
+		return true
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(int(dataNotLoadedRetryAfter/time.Second)))
+	writeJSONMessage(w, dataNotLoadedMsg, http.StatusServiceUnavailable)
+	return false
+}
+
+func writeCacheHeaders(w http.ResponseWriter, reporter staleDataReporter) {
+	writeSnapshotAge(w, reporter.snapshotCreatedAt())
+	writeStaleWarning(w, reporter.staleReason())
+}
+
+// The age of the served snapshot in seconds, which survives restarts when snapshots are read back from disk
+func writeSnapshotAge(w http.ResponseWriter, createdAt time.Time) {
+	if createdAt.IsZero() {
+		return
+	}
+	w.Header().Set("X-Snapshot-Age", strconv.FormatInt(int64(time.Since(createdAt)/time.Second), 10))
+}
+
+// The same version on __count and __ids tells the caller that the count matches the streamed UUIDs,
+// whichever replica answered each call
+func writeSnapshotVersion(w http.ResponseWriter, version string) {
+	w.Header().Set("X-Snapshot-Version", version)
+}
+
+// Tells the caller that the last refresh failed and the response comes from older data
+func writeStaleWarning(w http.ResponseWriter, staleReason error) {
+	if staleReason == nil {
+		return
+	}
+	w.Header().Set("Warning", `110 - "Response is Stale"`)
+	w.Header().Set("X-Last-Refresh-Error", strings.Replace(staleReason.Error(), "\n", " ", -1))
+}
+
+// A refused source response or a refresh refused by the mass deletion guard is reported with its reason,
+// so the caller doesn't have to parse the message
+func writeRefreshError(w http.ResponseWriter, msg string, err error) {
+	switch e := err.(type) {
+	case *sourceError:
+		writeJSONObject(w, map[string]string{"message": msg, "reason": refreshErrorReason(e), "source": e.source}, http.StatusInternalServerError)
+	case *massDeletionError:
+		writeJSONObject(w, map[string]interface{}{"message": msg, "reason": refreshErrorReason(e), "previous": e.previous, "current": e.current}, http.StatusConflict)
+	default:
+		writeJSONMessage(w, msg, http.StatusInternalServerError)
+	}
+}
+
+func writeJSONObject(w http.ResponseWriter, obj interface{}, statusCode int) {
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(statusCode)
-	fmt.Fprintln(w, fmt.Sprintf("{\"message\": \"%s\"}", errorMsg))
+	json.NewEncoder(w).Encode(obj)
+}
+
+func writeJSONMessage(w http.ResponseWriter, errorMsg string, statusCode int) {
+	writeJSONObject(w, map[string]string{"message": errorMsg}, statusCode)
 }
 
 func writeStreamResponse(ids []string, writer http.ResponseWriter) {
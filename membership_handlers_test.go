@@ -2,12 +2,15 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
@@ -22,16 +25,44 @@ var expectedStreamOutput = "{\"id\":\"" + expectedMembershipUUID + "\"}\n{\"id\"
 
 type MockedBerthaService struct {
 	mock.Mock
+	lastRefreshErr error
+	createdAt      time.Time
+	notLoaded      bool
+	contentHash    string
 }
 
-func (m *MockedBerthaService) refreshMembershipCache() error {
+func (m *MockedBerthaService) isLoaded() bool {
+	return !m.notLoaded
+}
+
+func (m *MockedBerthaService) staleReason() error {
+	return m.lastRefreshErr
+}
+
+func (m *MockedBerthaService) lastRefreshError() error {
+	return m.lastRefreshErr
+}
+
+func (m *MockedBerthaService) getCacheStatus() cacheStatus {
 	args := m.Called()
-	return args.Error(0)
+	return args.Get(0).(cacheStatus)
+}
+
+func (m *MockedBerthaService) snapshotCreatedAt() time.Time {
+	return m.createdAt
+}
+
+func (m *MockedBerthaService) reloadMembershipCache(force bool) (refreshResult, error) {
+	args := m.Called(force)
+	if err := args.Error(0); err != nil {
+		return refreshResult{}, err
+	}
+	return refreshResult{memberships: m.getMembershipCount(), rejected: len(m.getRejectedRecords())}, nil
 }
 
-func (m *MockedBerthaService) getMembershipUuids() []string {
+func (m *MockedBerthaService) previewMembershipRefresh() (membershipDiff, error) {
 	args := m.Called()
-	return args.Get(0).([]string)
+	return args.Get(0).(membershipDiff), args.Error(1)
 }
 
 func (m *MockedBerthaService) getMembershipByUuid(uuid string) membership {
@@ -44,6 +75,25 @@ func (m *MockedBerthaService) getMembershipCount() int {
 	return args.Int(0)
 }
 
+func (m *MockedBerthaService) getMembershipCountWithVersion() (int, string) {
+	return m.getMembershipCount(), m.contentHash
+}
+
+func (m *MockedBerthaService) getMembershipUuidsWithVersion() ([]string, string) {
+	args := m.Called()
+	return args.Get(0).([]string), m.contentHash
+}
+
+func (m *MockedBerthaService) getRejectedRecords() []rejectedRecord {
+	args := m.Called()
+	return args.Get(0).([]rejectedRecord)
+}
+
+func (m *MockedBerthaService) getMembershipChangesSince(token string) (changeSet, error) {
+	args := m.Called(token)
+	return args.Get(0).(changeSet), args.Error(1)
+}
+
 func (m *MockedBerthaService) checkAuthorsConnectivity() error {
 	args := m.Called()
 	return args.Error(0)
@@ -54,16 +104,105 @@ func (m *MockedBerthaService) checkRolesConnectivity() error {
 	return args.Error(0)
 }
 
+func (m *MockedBerthaService) checkCircuitBreakers() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockedBerthaService) checkMassDeletionGuard() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockedBerthaService) getPersonUuids() []string {
+	args := m.Called()
+	return args.Get(0).([]string)
+}
+
+func (m *MockedBerthaService) getPersonByUuid(uuid string) person {
+	args := m.Called(uuid)
+	return args.Get(0).(person)
+}
+
+func (m *MockedBerthaService) getPersonCount() int {
+	args := m.Called()
+	return args.Int(0)
+}
+
+func (m *MockedBerthaService) getRoleUuids() []string {
+	args := m.Called()
+	return args.Get(0).([]string)
+}
+
+func (m *MockedBerthaService) getRoleByUuid(uuid string) role {
+	args := m.Called(uuid)
+	return args.Get(0).(role)
+}
+
+func (m *MockedBerthaService) getRoleCount() int {
+	args := m.Called()
+	return args.Int(0)
+}
+
 func startCuratedAuthorsMembershipTransformer(bs *MockedBerthaService) {
-	mh := newMembershipHandler(bs)
-	h := setupServiceHandlers(mh)
+	startCuratedAuthorsMembershipTransformerRefreshingOnCount(bs, true)
+}
+
+func startCuratedAuthorsMembershipTransformerRefreshingOnCount(bs *MockedBerthaService, refreshOnCount bool) {
+	mh := newMembershipHandler(bs, refreshOnCount, defaultHealthThresholds)
+	ph := newPersonHandler(bs)
+	rh := newRoleHandler(bs)
+	h := setupServiceHandlers(mh, ph, rh, newHealthCache(mh.healthChecks(), time.Minute))
 	curatedAuthorsMembershipTransformer = httptest.NewServer(h)
 }
 
+// A call to the people or roles endpoints, which serve their concepts the same way
+type conceptEndpointTest struct {
+	name string
+	// The mocked service method, its arguments and what it returns
+	method string
+	args   []interface{}
+	result interface{}
+	path   string
+	status int
+	// The expected body, or the fixture of the expected JSON body
+	body     string
+	bodyFile string
+}
+
+func runConceptEndpointTests(t *testing.T, tests []conceptEndpointTest) {
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			mbs := new(MockedBerthaService)
+			mbs.On(test.method, test.args...).Return(test.result)
+			startCuratedAuthorsMembershipTransformer(mbs)
+			defer curatedAuthorsMembershipTransformer.Close()
+
+			resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + test.path)
+			if err != nil {
+				panic(err)
+			}
+			defer resp.Body.Close()
+
+			assert.Equal(t, test.status, resp.StatusCode)
+			actualOutput := getStringFromReader(resp.Body)
+			if test.bodyFile != "" {
+				file, _ := os.Open(test.bodyFile)
+				defer file.Close()
+				assert.Equal(t, "application/json", resp.Header.Get("Content-Type"), "Content-Type should be application/json")
+				assert.JSONEq(t, getStringFromReader(file), actualOutput)
+			} else if test.body != "" {
+				assert.Equal(t, test.body, actualOutput)
+			}
+		})
+	}
+}
+
 func TestShouldReturn200AndMembershipCount(t *testing.T) {
 	mbs := new(MockedBerthaService)
 	mbs.On("getMembershipCount").Return(2)
-	mbs.On("refreshMembershipCache").Return(nil)
+	mbs.On("reloadMembershipCache", false).Return(nil)
+	mbs.On("getRejectedRecords").Return([]rejectedRecord{})
 	startCuratedAuthorsMembershipTransformer(mbs)
 	defer curatedAuthorsMembershipTransformer.Close()
 
@@ -82,7 +221,7 @@ func TestShouldReturn200AndMembershipCount(t *testing.T) {
 func TestShouldReturn500WhenMembershipCountIsCalledAndCacheRefreshFails(t *testing.T) {
 	mbs := new(MockedBerthaService)
 	mbs.On("getMembershipCount").Return(2)
-	mbs.On("refreshMembershipCache").Return(errors.New("Exterminate!"))
+	mbs.On("reloadMembershipCache", false).Return(errors.New("Exterminate!"))
 	startCuratedAuthorsMembershipTransformer(mbs)
 	defer curatedAuthorsMembershipTransformer.Close()
 
@@ -95,13 +234,66 @@ func TestShouldReturn500WhenMembershipCountIsCalledAndCacheRefreshFails(t *testi
 	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "Response status should be 500")
 	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"), "Content-Type should be application/json")
 	actualOutput := getStringFromReader(resp.Body)
-	assert.Equal(t, "{\"message\": \"Exterminate!\"}\n", actualOutput, "Response body should contain the error message")
+	assert.JSONEq(t, `{"message": "Exterminate!"}`, actualOutput, "Response body should contain the error message")
+}
+
+func TestShouldEscapeQuotesInTheErrorMessage(t *testing.T) {
+	mbs := new(MockedBerthaService)
+	mbs.On("reloadMembershipCache", false).Return(errors.New(`Author at row 3 is invalid: Role UUID is not found for "Colunmist"`))
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Post(curatedAuthorsMembershipTransformer.URL+"/transformers/memberships/__reload", "", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "Response status should be 500")
+	var body map[string]string
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&body), "The error body should be valid JSON")
+	assert.Equal(t, `Author at row 3 is invalid: Role UUID is not found for "Colunmist"`, body["message"])
+}
+
+func TestShouldReturn200AndMembershipCountWithoutRefreshWhenRefreshOnCountIsOff(t *testing.T) {
+	mbs := new(MockedBerthaService)
+	mbs.On("getMembershipCount").Return(2)
+	startCuratedAuthorsMembershipTransformerRefreshingOnCount(mbs, false)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/__count")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Response status should be 200")
+	assert.Equal(t, "2", getStringFromReader(resp.Body), "Response body should contain the count of available authors")
+	mbs.AssertNotCalled(t, "reloadMembershipCache", false)
 }
 
 func TestShouldReturn200WhenMembershipCacheIsRefreshed(t *testing.T) {
 
 	mbs := new(MockedBerthaService)
-	mbs.On("refreshMembershipCache").Return(nil)
+	mbs.On("reloadMembershipCache", false).Return(nil)
+	mbs.On("getMembershipCount").Return(2)
+	mbs.On("getRejectedRecords").Return([]rejectedRecord{})
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Post(curatedAuthorsMembershipTransformer.URL+"/transformers/memberships/__reload", "", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Response status should be 200")
+}
+
+func TestShouldReturn500AndKeepServingStaleMembershipsWhenCacheRefreshFails(t *testing.T) {
+	mbs := &MockedBerthaService{lastRefreshErr: errors.New("Bertha is down")}
+	mbs.On("reloadMembershipCache", false).Return(errors.New("Bertha is down"))
+	mbs.On("getMembershipUuidsWithVersion").Return(uuids)
 	startCuratedAuthorsMembershipTransformer(mbs)
 	defer curatedAuthorsMembershipTransformer.Close()
 
@@ -111,12 +303,237 @@ func TestShouldReturn200WhenMembershipCacheIsRefreshed(t *testing.T) {
 	}
 	defer resp.Body.Close()
 
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "Response status should be 500")
+	assert.Equal(t, `110 - "Response is Stale"`, resp.Header.Get("Warning"), "The stale data should be flagged")
+	actualOutput := getStringFromReader(resp.Body)
+	assert.JSONEq(t, `{"message": "Refresh failed, serving memberships from the last successful refresh: Bertha is down"}`, actualOutput)
+
+	idsResp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/__ids")
+	if err != nil {
+		panic(err)
+	}
+	defer idsResp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, idsResp.StatusCode, "Response status should be 200")
+	assert.Equal(t, `110 - "Response is Stale"`, idsResp.Header.Get("Warning"), "The stale data should be flagged")
+	assert.Equal(t, "Bertha is down", idsResp.Header.Get("X-Last-Refresh-Error"))
+	assert.Equal(t, expectedStreamOutput, getStringFromReader(idsResp.Body), "The stale ids should still be served")
+}
+
+func TestShouldReturnSnapshotAgeWithMembershipUuids(t *testing.T) {
+	mbs := &MockedBerthaService{createdAt: time.Now().Add(-90 * time.Second)}
+	mbs.On("getMembershipUuidsWithVersion").Return(uuids)
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/__ids")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
 	assert.Equal(t, http.StatusOK, resp.StatusCode, "Response status should be 200")
+	assert.Equal(t, "90", resp.Header.Get("X-Snapshot-Age"), "The age of the snapshot should be given in seconds")
+}
+
+func TestShouldReturn503UntilDataIsLoaded(t *testing.T) {
+	mbs := &MockedBerthaService{notLoaded: true}
+	mbs.On("reloadMembershipCache", false).Return(errors.New("Bertha is down"))
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	for _, path := range []string{"__count", "__ids", "__rejected", "__changes", expectedMembershipUUID} {
+		resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/" + path)
+		if err != nil {
+			panic(err)
+		}
+		resp.Body.Close()
+
+		assert.Equal(t, http.StatusServiceUnavailable, resp.StatusCode, "Response status of %s should be 503", path)
+		assert.Equal(t, "30", resp.Header.Get("Retry-After"), "The caller of %s should be told when to try again", path)
+	}
+	mbs.AssertNotCalled(t, "getMembershipUuids")
+}
+
+func TestShouldNotBeGoodToGoUntilDataIsLoaded(t *testing.T) {
+	mh := newMembershipHandler(&MockedBerthaService{notLoaded: true}, true, defaultHealthThresholds)
+	status := mh.GTG()
+	assert.False(t, status.GoodToGo)
+	assert.Equal(t, "Data is not loaded yet", status.Message)
+}
+
+func TestShouldBeGoodToGoWhileBerthaIsUnreachableOnceDataIsLoaded(t *testing.T) {
+	mbs := new(MockedBerthaService)
+	mh := newMembershipHandler(mbs, true, defaultHealthThresholds)
+	status := mh.GTG()
+	assert.True(t, status.GoodToGo)
+	mbs.AssertNotCalled(t, "checkAuthorsConnectivity")
+	mbs.AssertNotCalled(t, "checkRolesConnectivity")
+}
+
+func TestShouldReportUnhealthySnapshotWhenNothingIsLoaded(t *testing.T) {
+	mh := newMembershipHandler(new(MockedBerthaService), true, defaultHealthThresholds)
+	_, err := mh.snapshotChecker()
+	assert.EqualError(t, err, "No snapshot is loaded")
+
+	mh = newMembershipHandler(&MockedBerthaService{createdAt: time.Now().Add(-time.Hour)}, true, defaultHealthThresholds)
+	msg, err := mh.snapshotChecker()
+	assert.Nil(t, err)
+	assert.Contains(t, msg, "1h0m0s ago")
+}
+
+func TestShouldReportUnhealthySnapshotWhenItIsTooOld(t *testing.T) {
+	createdAt := time.Now().Add(-time.Hour)
+	mh := newMembershipHandler(&MockedBerthaService{createdAt: createdAt}, true, healthThresholds{maxRefreshAge: 30 * time.Minute})
+	_, err := mh.snapshotChecker()
+	assert.EqualError(t, err, "Served snapshot was created at "+createdAt.Format(time.RFC3339)+", 1h0m0s ago, more than 30m0s")
+
+	mh = newMembershipHandler(&MockedBerthaService{createdAt: createdAt}, true, healthThresholds{})
+	msg, err := mh.snapshotChecker()
+	assert.Nil(t, err, "A zero maximum age should disable the check")
+	assert.Equal(t, "Serving snapshot created at "+createdAt.Format(time.RFC3339)+", 1h0m0s ago", msg)
+}
+
+func TestShouldReturnTheReasonWhenTheSourceIsRefused(t *testing.T) {
+	mbs := new(MockedBerthaService)
+	mbs.On("reloadMembershipCache", false).Return(&sourceError{source: "http://bertha/Authors", reason: unexpectedStatusReason, msg: "http://bertha/Authors returned unexpected HTTP status 502"})
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Post(curatedAuthorsMembershipTransformer.URL+"/transformers/memberships/__reload", "", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "Response status should be 500")
+	assert.JSONEq(t, `{"message": "http://bertha/Authors returned unexpected HTTP status 502", "reason": "unexpectedStatus", "source": "http://bertha/Authors"}`, getStringFromReader(resp.Body))
+}
+
+func TestShouldReturn409WhenMassDeletionGuardRefusesRefresh(t *testing.T) {
+	mbs := &MockedBerthaService{lastRefreshErr: &massDeletionError{previous: 400, current: 3}}
+	mbs.On("reloadMembershipCache", false).Return(&massDeletionError{previous: 400, current: 3})
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Post(curatedAuthorsMembershipTransformer.URL+"/transformers/memberships/__reload", "", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Response status should be 409")
+	assert.Equal(t, `110 - "Response is Stale"`, resp.Header.Get("Warning"), "The previous memberships are still served")
+	assert.JSONEq(t, `{"message": "Refresh failed, serving memberships from the last successful refresh: Refresh refused, memberships would drop from 400 to 3. Reload with force=true to accept the new data", "reason": "massDeletion", "previous": 400, "current": 3}`, getStringFromReader(resp.Body))
+}
+
+func TestShouldReturn409WhenMassDeletionGuardRefusesTheRefreshBeforeCounting(t *testing.T) {
+	mbs := &MockedBerthaService{lastRefreshErr: &massDeletionError{previous: 400, current: 3}}
+	mbs.On("reloadMembershipCache", false).Return(&massDeletionError{previous: 400, current: 3})
+	startCuratedAuthorsMembershipTransformerRefreshingOnCount(mbs, false)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/__count?refresh=true")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusConflict, resp.StatusCode, "Response status should be 409")
+	assert.Equal(t, `110 - "Response is Stale"`, resp.Header.Get("Warning"), "The previous memberships are still served")
+	assert.JSONEq(t, `{"message": "Refresh failed, serving memberships from the last successful refresh: Refresh refused, memberships would drop from 400 to 3. Reload with force=true to accept the new data", "reason": "massDeletion", "previous": 400, "current": 3}`, getStringFromReader(resp.Body))
+}
+
+func TestShouldForceRefreshWhenRequested(t *testing.T) {
+	mbs := new(MockedBerthaService)
+	mbs.On("reloadMembershipCache", true).Return(nil)
+	mbs.On("getMembershipCount").Return(2)
+	mbs.On("getRejectedRecords").Return([]rejectedRecord{})
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Post(curatedAuthorsMembershipTransformer.URL+"/transformers/memberships/__reload?force=true", "", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Response status should be 200")
+	mbs.AssertNotCalled(t, "reloadMembershipCache", false)
+}
+
+func TestShouldReturnDiffWithoutRefreshingOnDryRun(t *testing.T) {
+	mbs := new(MockedBerthaService)
+	mbs.On("previewMembershipRefresh").Return(membershipDiff{
+		Added:   []membershipChange{},
+		Removed: []membershipChange{{UUID: membership2.UUID, Fields: []fieldChange{{Field: "uuid", Old: membership2.UUID}}}},
+		Changed: []membershipChange{},
+	}, nil)
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Post(curatedAuthorsMembershipTransformer.URL+"/transformers/memberships/__reload?dryRun=true", "", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Response status should be 200")
+	assert.JSONEq(t, `{"added": [], "removed": [{"uuid": "`+membership2.UUID+`", "fields": [{"field": "uuid", "old": "`+membership2.UUID+`"}]}], "changed": []}`, getStringFromReader(resp.Body))
+	mbs.AssertNotCalled(t, "reloadMembershipCache", false)
+}
+
+func TestShouldReturn202AndTrackTheReloadJobWhenAsync(t *testing.T) {
+	mbs := new(MockedBerthaService)
+	mbs.On("reloadMembershipCache", false).Return(nil)
+	mbs.On("getMembershipCount").Return(2)
+	mbs.On("getRejectedRecords").Return([]rejectedRecord{{Sheet: authorsSheet, Row: 3}})
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Post(curatedAuthorsMembershipTransformer.URL+"/transformers/memberships/__reload?async=true", "", nil)
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusAccepted, resp.StatusCode, "Response status should be 202")
+	var job reloadJob
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&job))
+	assert.NotEmpty(t, job.ID)
+	assert.Equal(t, "/transformers/memberships/__reload/"+job.ID, resp.Header.Get("Location"))
+
+	for job.Status == jobQueued || job.Status == jobRunning {
+		jobResp, err := http.Get(curatedAuthorsMembershipTransformer.URL + resp.Header.Get("Location"))
+		if err != nil {
+			panic(err)
+		}
+		assert.Equal(t, http.StatusOK, jobResp.StatusCode, "Response status should be 200")
+		assert.Nil(t, json.NewDecoder(jobResp.Body).Decode(&job))
+		jobResp.Body.Close()
+	}
+	assert.Equal(t, jobSucceeded, job.Status)
+	assert.Equal(t, 2, *job.Memberships)
+	assert.Equal(t, 1, *job.Rejected)
+	assert.NotEmpty(t, job.Duration)
+}
+
+func TestShouldReturn404WhenReloadJobIsNotFound(t *testing.T) {
+	startCuratedAuthorsMembershipTransformer(new(MockedBerthaService))
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/__reload/5b1f5e8e-6f7c-4b0e-9d4c-0c9a0f3e1d2a")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Response status should be 404")
 }
 
 func TestShouldReturn200AndMembershipUuids(t *testing.T) {
 	mbs := new(MockedBerthaService)
-	mbs.On("getMembershipUuids").Return(uuids, nil)
+	mbs.On("getMembershipUuidsWithVersion").Return(uuids, nil)
 	startCuratedAuthorsMembershipTransformer(mbs)
 	defer curatedAuthorsMembershipTransformer.Close()
 
@@ -177,9 +594,76 @@ func TestShouldReturn404WhenMembershipIsNotFound(t *testing.T) {
 	assert.Equal(t, http.StatusNotFound, resp.StatusCode, "Response status should be 404")
 }
 
+func TestShouldReturn200AndRejectedRecords(t *testing.T) {
+	mbs := new(MockedBerthaService)
+	mbs.On("getRejectedRecords").Return([]rejectedRecord{
+		{Sheet: authorsSheet, Row: 3, ID: anotherAuthorTmeIdentifier, Name: "Lucy Kellaway", Reason: unknownRoleReason, Message: `Role UUID is not found for "Colunmist"`},
+	})
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/__rejected")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Response status should be 200")
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"), "Content-Type should be application/json")
+	expectedOutput := `[{"sheet":"authors","row":3,"id":"Q0ItMDAwMDkyNg==-QXV0aG9ycw==","name":"Lucy Kellaway","reason":"unknownRole","message":"Role UUID is not found for \"Colunmist\""}]`
+	assert.JSONEq(t, expectedOutput, getStringFromReader(resp.Body), "Response body should list the rejected records")
+}
+
+func TestShouldReturn200AndMembershipChanges(t *testing.T) {
+	mbs := new(MockedBerthaService)
+	mbs.On("getMembershipChangesSince", "1.2").Return(changeSet{Since: "1.2", Token: "1.4", Created: []string{expectedMembershipUUID}, Updated: []string{}, Deleted: []string{"e06be0f8-0426-4ee8-80e3-3da37255818a"}}, nil)
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/__changes?since=1.2")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Response status should be 200")
+	expectedOutput := `{"since":"1.2","token":"1.4","created":["` + expectedMembershipUUID + `"],"updated":[],"deleted":["e06be0f8-0426-4ee8-80e3-3da37255818a"]}`
+	assert.JSONEq(t, expectedOutput, getStringFromReader(resp.Body), "Response body should list the changes")
+}
+
+func TestShouldReturn410WhenChangeTokenHasExpired(t *testing.T) {
+	mbs := new(MockedBerthaService)
+	mbs.On("getMembershipChangesSince", "1.2").Return(changeSet{}, errExpiredChangeToken)
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/__changes?since=1.2")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusGone, resp.StatusCode, "Response status should be 410")
+}
+
+func TestShouldReturn400WhenChangeTokenIsInvalid(t *testing.T) {
+	mbs := new(MockedBerthaService)
+	mbs.On("getMembershipChangesSince", "abc").Return(changeSet{}, errInvalidChangeToken)
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/__changes?since=abc")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode, "Response status should be 400")
+}
+
 func TestShouldReturn500WhenCacheRefreshReturnsError(t *testing.T) {
 	mbs := new(MockedBerthaService)
-	mbs.On("refreshMembershipCache").Return(errors.New("I am a zombie"))
+	mbs.On("reloadMembershipCache", false).Return(errors.New("I am a zombie"))
 	startCuratedAuthorsMembershipTransformer(mbs)
 	defer curatedAuthorsMembershipTransformer.Close()
 
@@ -191,3 +675,130 @@ func TestShouldReturn500WhenCacheRefreshReturnsError(t *testing.T) {
 
 	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, "Response status should be 500")
 }
+
+func TestShouldServeMetrics(t *testing.T) {
+	startCuratedAuthorsMembershipTransformer(new(MockedBerthaService))
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/__metrics")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Response status should be 200")
+	assert.Contains(t, resp.Header.Get("Content-Type"), "text/plain; version=0.0.4")
+	assert.Contains(t, getStringFromReader(resp.Body), "# TYPE go_goroutines gauge\n", "The metrics of the default registry should be served")
+}
+
+func TestHealthChecksShouldHaveTheirOwnPanicGuideAndImpact(t *testing.T) {
+	mh := newMembershipHandler(new(MockedBerthaService), true, defaultHealthThresholds)
+	checks := mh.healthChecks()
+	guides := map[string]bool{}
+	impacts := map[string]bool{}
+	for _, c := range checks {
+		assert.True(t, strings.HasPrefix(c.PanicGuide, panicGuide+"#"), "%s should link to a section of the runbook", c.Name)
+		guides[c.PanicGuide] = true
+		impacts[c.BusinessImpact] = true
+	}
+	assert.Len(t, guides, len(checks), "Every check should have its own panic guide")
+	assert.Len(t, impacts, len(checks), "Every check should have its own business impact")
+}
+
+func TestShouldReportUnhealthyWhenTheLastRefreshFailed(t *testing.T) {
+	mh := newMembershipHandler(new(MockedBerthaService), true, defaultHealthThresholds)
+	_, err := mh.lastRefreshChecker()
+	assert.Nil(t, err)
+
+	mh = newMembershipHandler(&MockedBerthaService{lastRefreshErr: errors.New("Bertha is down"), notLoaded: true}, true, defaultHealthThresholds)
+	_, err = mh.lastRefreshChecker()
+	assert.EqualError(t, err, "Bertha is down", "The error should be reported even before any data is loaded")
+}
+
+func TestShouldReportUnhealthyWhenTooFewMembershipsAreServed(t *testing.T) {
+	mbs := new(MockedBerthaService)
+	mbs.On("getMembershipCount").Return(0).Once()
+	mbs.On("getMembershipCount").Return(5).Once()
+	mbs.On("getMembershipCount").Return(10).Once()
+	mh := newMembershipHandler(mbs, true, healthThresholds{minMemberships: 0})
+	_, err := mh.membershipCountChecker()
+	assert.EqualError(t, err, "0 memberships are served, the minimum is 0", "No memberships should always be unhealthy")
+
+	mh = newMembershipHandler(mbs, true, healthThresholds{minMemberships: 10})
+	_, err = mh.membershipCountChecker()
+	assert.EqualError(t, err, "5 memberships are served, the minimum is 10")
+
+	msg, err := mh.membershipCountChecker()
+	assert.Nil(t, err)
+	assert.Equal(t, "10 memberships are served", msg)
+}
+
+func TestShouldReturnTheCacheStatusBeforeDataIsLoaded(t *testing.T) {
+	mbs := &MockedBerthaService{notLoaded: true}
+	mbs.On("getCacheStatus").Return(cacheStatus{
+		Sources:         sourcePair{Authors: sourceVersion{URL: "http://bertha/authors"}, Roles: sourceVersion{URL: "http://bertha/roles"}},
+		LastError:       "Bertha is down",
+		LastErrorReason: unexpectedStatusReason,
+	})
+	startCuratedAuthorsMembershipTransformer(mbs)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/__status")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Response status should be 200")
+	assert.Equal(t, "application/json", resp.Header.Get("Content-Type"))
+	var status map[string]interface{}
+	assert.Nil(t, json.NewDecoder(resp.Body).Decode(&status))
+	assert.Equal(t, "Bertha is down", status["lastError"])
+	assert.Equal(t, unexpectedStatusReason, status["lastErrorReason"])
+	assert.Equal(t, "http://bertha/authors", status["sources"].(map[string]interface{})["authors"].(map[string]interface{})["url"])
+	assert.NotContains(t, status, "lastSuccessAt")
+}
+
+func TestShouldCountWithoutRefreshingUnlessAsked(t *testing.T) {
+	mbs := &MockedBerthaService{contentHash: "4f1c9a"}
+	mbs.On("getMembershipCount").Return(2)
+	mbs.On("getMembershipUuidsWithVersion").Return(uuids)
+	startCuratedAuthorsMembershipTransformerRefreshingOnCount(mbs, false)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/__count")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Response status should be 200")
+	assert.Equal(t, "2", getStringFromReader(resp.Body))
+	assert.Equal(t, "4f1c9a", resp.Header.Get("X-Snapshot-Version"))
+	mbs.AssertNotCalled(t, "reloadMembershipCache", false)
+
+	resp, err = http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/__ids")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "4f1c9a", resp.Header.Get("X-Snapshot-Version"), "__ids should return the version of __count")
+}
+
+func TestShouldRefreshBeforeCountingWhenAsked(t *testing.T) {
+	mbs := &MockedBerthaService{contentHash: "b72e05"}
+	mbs.On("getMembershipCount").Return(2)
+	mbs.On("reloadMembershipCache", false).Return(nil)
+	mbs.On("getRejectedRecords").Return([]rejectedRecord{})
+	startCuratedAuthorsMembershipTransformerRefreshingOnCount(mbs, false)
+	defer curatedAuthorsMembershipTransformer.Close()
+
+	resp, err := http.Get(curatedAuthorsMembershipTransformer.URL + "/transformers/memberships/__count?refresh=true")
+	if err != nil {
+		panic(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode, "Response status should be 200")
+	assert.Equal(t, "2", getStringFromReader(resp.Body))
+	assert.Equal(t, "b72e05", resp.Header.Get("X-Snapshot-Version"))
+	mbs.AssertCalled(t, "reloadMembershipCache", false)
+}
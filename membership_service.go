@@ -1,10 +1,29 @@
 package main
 
+import "time"
+
+// Reports whether any data is loaded yet, how old the served data is, and the error of the last refresh
+// while the data of an earlier successful refresh is still being served
+type staleDataReporter interface {
+	isLoaded() bool
+	staleReason() error
+	snapshotCreatedAt() time.Time
+}
+
 type membershipService interface {
-	refreshMembershipCache() error
+	staleDataReporter
+	reloadMembershipCache(force bool) (refreshResult, error)
+	previewMembershipRefresh() (membershipDiff, error)
 	getMembershipCount() int
-	getMembershipUuids() []string
+	getMembershipCountWithVersion() (int, string)
+	getMembershipUuidsWithVersion() ([]string, string)
 	getMembershipByUuid(uuid string) membership
+	getRejectedRecords() []rejectedRecord
+	getMembershipChangesSince(token string) (changeSet, error)
 	checkAuthorsConnectivity() error
 	checkRolesConnectivity() error
+	checkCircuitBreakers() error
+	checkMassDeletionGuard() error
+	lastRefreshError() error
+	getCacheStatus() cacheStatus
 }
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const metricsNamespace = "curated_authors_memberships"
+
+var durationBuckets = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// The metrics of refreshes and Bertha calls, served in the Prometheus format on /__metrics
+type serviceMetrics struct {
+	refreshDuration       *prometheus.HistogramVec
+	refreshes             *prometheus.CounterVec
+	fetchDuration         *prometheus.HistogramVec
+	fetchResponses        *prometheus.CounterVec
+	cacheRequests         *prometheus.CounterVec
+	rejectedRecords       *prometheus.CounterVec
+	lastSuccessfulRefresh prometheus.Gauge
+}
+
+var promMetrics = newServiceMetrics()
+
+func newServiceMetrics() *serviceMetrics {
+	return &serviceMetrics{
+		refreshDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "refresh_duration_seconds",
+			Help:      "Duration of the refreshes of the memberships",
+			Buckets:   durationBuckets,
+		}, []string{"result"}),
+		refreshes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "refreshes_total",
+			Help:      "Refreshes of the memberships by result",
+		}, []string{"result"}),
+		fetchDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "bertha_fetch_duration_seconds",
+			Help:      "Duration of the calls to Bertha",
+			Buckets:   durationBuckets,
+		}, []string{"url"}),
+		fetchResponses: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bertha_fetch_responses_total",
+			Help:      "Calls to Bertha by HTTP status, or error when no response came back",
+		}, []string{"url", "status"}),
+		cacheRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "bertha_cache_requests_total",
+			Help:      "Calls to Bertha answered by the HTTP cache or not",
+		}, []string{"url", "result"}),
+		rejectedRecords: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "rejected_records_total",
+			Help:      "Records skipped by the refreshes, by reason",
+		}, []string{"reason"}),
+		lastSuccessfulRefresh: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_successful_refresh_timestamp_seconds",
+			Help:      "Unix time of the last successful refresh",
+		}),
+	}
+}
+
+// The counts are read from the services when the metrics are scraped
+func (m *serviceMetrics) register(r prometheus.Registerer, ms membershipService, rs roleService) {
+	r.MustRegister(
+		m.refreshDuration,
+		m.refreshes,
+		m.fetchDuration,
+		m.fetchResponses,
+		m.cacheRequests,
+		m.rejectedRecords,
+		m.lastSuccessfulRefresh,
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "memberships",
+			Help:      "Memberships currently served",
+		}, func() float64 { return float64(ms.getMembershipCount()) }),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "roles",
+			Help:      "Roles currently served",
+		}, func() float64 { return float64(rs.getRoleCount()) }),
+	)
+}
+
+func (m *serviceMetrics) observeRefresh(started time.Time, err error, rejected []rejectedRecord) {
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	m.refreshDuration.WithLabelValues(result).Observe(time.Since(started).Seconds())
+	m.refreshes.WithLabelValues(result).Inc()
+	if err != nil {
+		return
+	}
+	for _, r := range rejected {
+		m.rejectedRecords.WithLabelValues(r.Reason).Inc()
+	}
+	m.lastSuccessfulRefresh.Set(float64(time.Now().UnixNano()) / float64(time.Second))
+}
+
+// httpcache marks the responses it serves with X-From-Cache
+func (m *serviceMetrics) observeFetch(url string, started time.Time, resp *http.Response) {
+	m.fetchDuration.WithLabelValues(url).Observe(time.Since(started).Seconds())
+	if resp == nil {
+		m.fetchResponses.WithLabelValues(url, "error").Inc()
+		return
+	}
+	m.fetchResponses.WithLabelValues(url, strconv.Itoa(resp.StatusCode)).Inc()
+	if resp.Header.Get("X-From-Cache") == "1" {
+		m.cacheRequests.WithLabelValues(url, "hit").Inc()
+	} else {
+		m.cacheRequests.WithLabelValues(url, "miss").Inc()
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/stretchr/testify/assert"
+)
+
+// Serves the metrics from a registry of their own, so the tests don't share the default one
+func scrapeMetrics(m *serviceMetrics, ms membershipService, rs roleService) string {
+	registry := prometheus.NewRegistry()
+	m.register(registry, ms, rs)
+	w := httptest.NewRecorder()
+	promhttp.HandlerFor(registry, promhttp.HandlerOpts{}).ServeHTTP(w, httptest.NewRequest("GET", "/__metrics", nil))
+	return w.Body.String()
+}
+
+func TestMetricsShouldCountRefreshesByResult(t *testing.T) {
+	m := newServiceMetrics()
+	m.observeRefresh(time.Now(), nil, []rejectedRecord{{Reason: unknownRoleReason}, {Reason: unknownRoleReason}, {Reason: invalidDateReason}})
+	m.observeRefresh(time.Now(), errors.New("Exterminate!"), []rejectedRecord{{Reason: unknownRoleReason}})
+
+	mbs := new(MockedBerthaService)
+	mbs.On("getMembershipCount").Return(2)
+	mbs.On("getRoleCount").Return(3)
+	output := scrapeMetrics(m, mbs, mbs)
+	assert.Contains(t, output, "# TYPE curated_authors_memberships_refreshes_total counter\n")
+	assert.Contains(t, output, `curated_authors_memberships_refreshes_total{result="failure"} 1`)
+	assert.Contains(t, output, `curated_authors_memberships_refreshes_total{result="success"} 1`)
+	assert.Contains(t, output, `curated_authors_memberships_rejected_records_total{reason="invalidDate"} 1`)
+	assert.Contains(t, output, `curated_authors_memberships_rejected_records_total{reason="unknownRole"} 2`, "The rejected records of a failed refresh should not be counted")
+	assert.Contains(t, output, `curated_authors_memberships_refresh_duration_seconds_bucket{result="success",le="+Inf"} 1`)
+	assert.Contains(t, output, `curated_authors_memberships_refresh_duration_seconds_count{result="failure"} 1`)
+	assert.Contains(t, output, "# TYPE curated_authors_memberships_last_successful_refresh_timestamp_seconds gauge\n")
+	assert.NotContains(t, output, "curated_authors_memberships_last_successful_refresh_timestamp_seconds 0\n")
+}
+
+func TestMetricsShouldCountFetchesByStatusAndCacheResult(t *testing.T) {
+	m := newServiceMetrics()
+	cached := &http.Response{StatusCode: http.StatusOK, Header: http.Header{"X-From-Cache": []string{"1"}}}
+	m.observeFetch("http://bertha/authors", time.Now(), cached)
+	m.observeFetch("http://bertha/authors", time.Now(), &http.Response{StatusCode: http.StatusServiceUnavailable, Header: http.Header{}})
+	m.observeFetch("http://bertha/authors", time.Now(), nil)
+
+	mbs := new(MockedBerthaService)
+	mbs.On("getMembershipCount").Return(2)
+	mbs.On("getRoleCount").Return(3)
+	output := scrapeMetrics(m, mbs, mbs)
+	assert.Contains(t, output, `curated_authors_memberships_bertha_fetch_responses_total{status="200",url="http://bertha/authors"} 1`)
+	assert.Contains(t, output, `curated_authors_memberships_bertha_fetch_responses_total{status="503",url="http://bertha/authors"} 1`)
+	assert.Contains(t, output, `curated_authors_memberships_bertha_fetch_responses_total{status="error",url="http://bertha/authors"} 1`)
+	assert.Contains(t, output, `curated_authors_memberships_bertha_cache_requests_total{result="hit",url="http://bertha/authors"} 1`)
+	assert.Contains(t, output, `curated_authors_memberships_bertha_cache_requests_total{result="miss",url="http://bertha/authors"} 1`)
+}
+
+func TestMetricsShouldReadTheCurrentCountsWhenScraped(t *testing.T) {
+	mbs := new(MockedBerthaService)
+	mbs.On("getMembershipCount").Return(2)
+	mbs.On("getRoleCount").Return(3)
+
+	output := scrapeMetrics(newServiceMetrics(), mbs, mbs)
+	assert.Contains(t, output, "curated_authors_memberships_memberships 2\n")
+	assert.Contains(t, output, "curated_authors_memberships_roles 3\n")
+}
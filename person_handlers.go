@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/gorilla/mux"
+)
+
+type personHandler struct {
+	personService personService
+}
+
+func newPersonHandler(ps personService) personHandler {
+	return personHandler{
+		personService: ps,
+	}
+}
+
+func (ph *personHandler) getPeopleCount(writer http.ResponseWriter, req *http.Request) {
+	if !requireLoadedData(writer, ph.personService) {
+		return
+	}
+	writeCacheHeaders(writer, ph.personService)
+	c := ph.personService.getPersonCount()
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf(`%v`, c))
+	buffer.WriteTo(writer)
+}
+
+func (ph *personHandler) getPeopleUuids(writer http.ResponseWriter, req *http.Request) {
+	if !requireLoadedData(writer, ph.personService) {
+		return
+	}
+	writeCacheHeaders(writer, ph.personService)
+	uuids := ph.personService.getPersonUuids()
+	writeStreamResponse(uuids, writer)
+}
+
+func (ph *personHandler) getPersonByUuid(writer http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	uuid := vars["uuid"]
+	if !requireLoadedData(writer, ph.personService) {
+		return
+	}
+	writeCacheHeaders(writer, ph.personService)
+	p := ph.personService.getPersonByUuid(uuid)
+	writeJSONResponse(p, !reflect.DeepEqual(p, person{}), "Person not found", writer)
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+//For fixtures see fixtures_test.go
+
+func TestPeopleEndpoints(t *testing.T) {
+	runConceptEndpointTests(t, []conceptEndpointTest{
+		{
+			name:   "count",
+			method: "getPersonCount",
+			result: 2,
+			path:   "/transformers/people/__count",
+			status: http.StatusOK,
+			body:   "2",
+		},
+		{
+			name:   "ids",
+			method: "getPersonUuids",
+			result: []string{expectedAuthorUUID},
+			path:   "/transformers/people/__ids",
+			status: http.StatusOK,
+			body:   "{\"id\":\"" + expectedAuthorUUID + "\"}\n",
+		},
+		{
+			name:     "person",
+			method:   "getPersonByUuid",
+			args:     []interface{}{expectedAuthorUUID},
+			result:   expectedPerson,
+			path:     "/transformers/people/" + expectedAuthorUUID,
+			status:   http.StatusOK,
+			bodyFile: "test-resources/transformed-person-output.json",
+		},
+		{
+			name:   "person not found",
+			method: "getPersonByUuid",
+			args:   []interface{}{expectedAuthorUUID},
+			result: person{},
+			path:   "/transformers/people/" + expectedAuthorUUID,
+			status: http.StatusNotFound,
+		},
+	})
+}
@@ -0,0 +1,8 @@
+package main
+
+type personService interface {
+	staleDataReporter
+	getPersonCount() int
+	getPersonUuids() []string
+	getPersonByUuid(uuid string) person
+}
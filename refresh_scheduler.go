@@ -0,0 +1,71 @@
+package main
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type cacheRefresher interface {
+	refreshMembershipCache() error
+}
+
+// Refreshes the cache in the background. Every wait gets a random jitter added,
+// so replicas started together don't call Bertha at the same moment.
+type refreshScheduler struct {
+	refresher cacheRefresher
+	interval  time.Duration
+	jitter    time.Duration
+	random    *rand.Rand
+	quit      chan struct{}
+	done      sync.WaitGroup
+}
+
+func newRefreshScheduler(refresher cacheRefresher, interval time.Duration, jitter time.Duration) *refreshScheduler {
+	return &refreshScheduler{
+		refresher: refresher,
+		interval:  interval,
+		jitter:    jitter,
+		random:    rand.New(rand.NewSource(time.Now().UnixNano())),
+		quit:      make(chan struct{}),
+	}
+}
+
+func (rs *refreshScheduler) start() {
+	log.WithFields(log.Fields{"interval": rs.interval, "jitter": rs.jitter}).Info("Scheduling cache refresh")
+	rs.done.Add(1)
+	go rs.run()
+}
+
+func (rs *refreshScheduler) stop() {
+	close(rs.quit)
+	rs.done.Wait()
+}
+
+func (rs *refreshScheduler) run() {
+	defer rs.done.Done()
+	timer := time.NewTimer(rs.nextDelay())
+	defer timer.Stop()
+	for {
+		select {
+		case <-rs.quit:
+			return
+		case <-timer.C:
+			if err := rs.refresher.refreshMembershipCache(); err != nil {
+				log.WithError(err).Warn("Scheduled cache refresh failed")
+			} else {
+				log.Info("Scheduled cache refresh succeeded")
+			}
+			timer.Reset(rs.nextDelay())
+		}
+	}
+}
+
+func (rs *refreshScheduler) nextDelay() time.Duration {
+	if rs.jitter <= 0 {
+		return rs.interval
+	}
+	return rs.interval + time.Duration(rs.random.Int63n(int64(rs.jitter)))
+}
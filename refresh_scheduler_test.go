@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type countingRefresher struct {
+	refreshes chan time.Time
+}
+
+func (cr *countingRefresher) refreshMembershipCache() error {
+	cr.refreshes <- time.Now()
+	return errors.New("Bertha is down")
+}
+
+func TestShouldRefreshRepeatedlyEvenWhenRefreshFails(t *testing.T) {
+	refresher := &countingRefresher{refreshes: make(chan time.Time, 10)}
+	rs := newRefreshScheduler(refresher, 10*time.Millisecond, 5*time.Millisecond)
+	rs.start()
+
+	for i := 0; i < 3; i++ {
+		select {
+		case <-refresher.refreshes:
+		case <-time.After(time.Second):
+			t.Fatalf("Refresh %d did not happen", i+1)
+		}
+	}
+	rs.stop()
+}
+
+func TestShouldNotRefreshAfterStop(t *testing.T) {
+	refresher := &countingRefresher{refreshes: make(chan time.Time, 10)}
+	rs := newRefreshScheduler(refresher, 20*time.Millisecond, 0)
+	rs.start()
+	rs.stop()
+
+	select {
+	case <-refresher.refreshes:
+		t.Fatal("No refresh should happen after stop")
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func TestShouldAddJitterWithinBounds(t *testing.T) {
+	rs := newRefreshScheduler(nil, time.Minute, 10*time.Second)
+	for i := 0; i < 100; i++ {
+		d := rs.nextDelay()
+		assert.True(t, d >= time.Minute && d < time.Minute+10*time.Second, "Delay %v should be within the jitter", d)
+	}
+
+	assert.Equal(t, time.Minute, newRefreshScheduler(nil, time.Minute, 0).nextDelay(), "No jitter should be added when it is disabled")
+}
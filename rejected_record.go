@@ -0,0 +1,33 @@
+package main
+
+const authorsSheet = "authors"
+
+// A source record that was left out of the published data, with the reason why
+type rejectedRecord struct {
+	Sheet   string `json:"sheet"`
+	Row     int    `json:"row"`
+	ID      string `json:"id,omitempty"`
+	Name    string `json:"name,omitempty"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// Spreadsheet rows count from 1 and the first one holds the column headers
+func sheetRow(index int) int {
+	return index + 2
+}
+
+func rejectedAuthor(index int, a author, err error) rejectedRecord {
+	reason := "invalidAuthor"
+	if te, ok := err.(*transformError); ok {
+		reason = te.reason
+	}
+	return rejectedRecord{
+		Sheet:   authorsSheet,
+		Row:     sheetRow(index),
+		ID:      a.TmeIdentifier,
+		Name:    a.Name,
+		Reason:  reason,
+		Message: err.Error(),
+	}
+}
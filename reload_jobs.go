@@ -0,0 +1,168 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/pborman/uuid"
+)
+
+const (
+	jobQueued    = "queued"
+	jobRunning   = "running"
+	jobSucceeded = "succeeded"
+	jobFailed    = "failed"
+)
+
+const maxReloadJobs = 100
+
+type reloader interface {
+	reloadMembershipCache(force bool) (refreshResult, error)
+}
+
+// The counts of the snapshot a reload applied
+type refreshResult struct {
+	memberships int
+	rejected    int
+}
+
+type reloadJob struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Force       bool       `json:"force"`
+	QueuedAt    time.Time  `json:"queuedAt"`
+	StartedAt   *time.Time `json:"startedAt,omitempty"`
+	FinishedAt  *time.Time `json:"finishedAt,omitempty"`
+	Duration    string     `json:"duration,omitempty"`
+	Memberships *int       `json:"memberships,omitempty"`
+	Rejected    *int       `json:"rejected,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	err         error
+	done        chan struct{}
+}
+
+// Runs the reloads one at a time. While one runs, the reload requests are coalesced into a single queued job,
+// which reads the spreadsheets once for all of them when the running one is over.
+type reloadJobs struct {
+	reloader reloader
+	jobs     map[string]*reloadJob
+	// IDs in submission order, so the oldest jobs are forgotten first
+	ids     []string
+	running *reloadJob
+	queued  *reloadJob
+	mutex   *sync.Mutex
+}
+
+func newReloadJobs(r reloader) *reloadJobs {
+	return &reloadJobs{
+		reloader: r,
+		jobs:     make(map[string]*reloadJob),
+		mutex:    &sync.Mutex{},
+	}
+}
+
+// Returns the job that will carry out the reload, which is the queued one when there is one already.
+// A forced reload makes the queued job forced.
+func (rj *reloadJobs) submit(force bool) reloadJob {
+	rj.mutex.Lock()
+	defer rj.mutex.Unlock()
+	if rj.queued != nil {
+		rj.queued.Force = rj.queued.Force || force
+		return *rj.queued
+	}
+
+	job := &reloadJob{ID: uuid.New(), Status: jobQueued, Force: force, QueuedAt: time.Now().UTC(), done: make(chan struct{})}
+	rj.remember(job)
+	if rj.running != nil {
+		rj.queued = job
+	} else {
+		rj.running = job
+		go rj.run(job)
+	}
+	return *job
+}
+
+func (rj *reloadJobs) get(id string) (reloadJob, bool) {
+	rj.mutex.Lock()
+	defer rj.mutex.Unlock()
+	job, found := rj.jobs[id]
+	if !found {
+		return reloadJob{}, false
+	}
+	return *job, true
+}
+
+// Blocks until the job is over and returns its final state
+func (rj *reloadJobs) wait(id string) (reloadJob, bool) {
+	rj.mutex.Lock()
+	job, found := rj.jobs[id]
+	rj.mutex.Unlock()
+	if !found {
+		return reloadJob{}, false
+	}
+	<-job.done
+
+	rj.mutex.Lock()
+	defer rj.mutex.Unlock()
+	return *job, true
+}
+
+func (rj *reloadJobs) run(job *reloadJob) {
+	for job != nil {
+		rj.execute(job)
+
+		rj.mutex.Lock()
+		job, rj.queued = rj.queued, nil
+		rj.running = job
+		rj.mutex.Unlock()
+	}
+}
+
+func (rj *reloadJobs) execute(job *reloadJob) {
+	rj.mutex.Lock()
+	started := time.Now().UTC()
+	job.Status = jobRunning
+	job.StartedAt = &started
+	force := job.Force
+	rj.mutex.Unlock()
+
+	result, err := rj.reloader.reloadMembershipCache(force)
+
+	rj.mutex.Lock()
+	defer rj.mutex.Unlock()
+	finished := time.Now().UTC()
+	job.FinishedAt = &finished
+	job.Duration = finished.Sub(started).String()
+	if err != nil {
+		job.Status = jobFailed
+		job.Error = err.Error()
+		job.Reason = refreshErrorReason(err)
+		job.err = err
+	} else {
+		job.Status = jobSucceeded
+		job.Memberships = &result.memberships
+		job.Rejected = &result.rejected
+	}
+	close(job.done)
+}
+
+func (rj *reloadJobs) remember(job *reloadJob) {
+	rj.jobs[job.ID] = job
+	rj.ids = append(rj.ids, job.ID)
+	for len(rj.ids) > maxReloadJobs {
+		delete(rj.jobs, rj.ids[0])
+		rj.ids = rj.ids[1:]
+	}
+}
+
+func refreshErrorReason(err error) string {
+	switch e := err.(type) {
+	case *sourceError:
+		return e.reason
+	case *massDeletionError:
+		return massDeletionReason
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Every reload blocks until it is released, and records whether it was forced
+type blockingReloader struct {
+	started chan bool
+	release chan error
+}
+
+func newBlockingReloader() *blockingReloader {
+	return &blockingReloader{started: make(chan bool, 10), release: make(chan error)}
+}
+
+func (br *blockingReloader) reloadMembershipCache(force bool) (refreshResult, error) {
+	br.started <- force
+	if err := <-br.release; err != nil {
+		return refreshResult{}, err
+	}
+	return refreshResult{memberships: 2}, nil
+}
+
+func (br *blockingReloader) awaitStart(t *testing.T) bool {
+	select {
+	case force := <-br.started:
+		return force
+	case <-time.After(time.Second):
+		t.Fatal("The reload did not start")
+		return false
+	}
+}
+
+func TestShouldCoalesceReloadsIntoOneQueuedJob(t *testing.T) {
+	br := newBlockingReloader()
+	rj := newReloadJobs(br)
+
+	first := rj.submit(false)
+	br.awaitStart(t)
+	running, _ := rj.get(first.ID)
+	assert.Equal(t, jobRunning, running.Status)
+
+	second := rj.submit(false)
+	third := rj.submit(true)
+	assert.NotEqual(t, first.ID, second.ID)
+	assert.Equal(t, second.ID, third.ID, "The requests made while one runs should share the queued job")
+	queued, _ := rj.get(second.ID)
+	assert.Equal(t, jobQueued, queued.Status)
+	assert.True(t, queued.Force, "A forced request should force the queued job")
+
+	br.release <- nil
+	assert.True(t, br.awaitStart(t), "The queued job should run forced")
+	br.release <- errors.New("Bertha is down")
+
+	done, _ := rj.wait(second.ID)
+	assert.Equal(t, jobFailed, done.Status)
+	assert.Equal(t, "Bertha is down", done.Error)
+	assert.Nil(t, done.Memberships)
+
+	done, _ = rj.wait(first.ID)
+	assert.Equal(t, jobSucceeded, done.Status)
+	assert.Equal(t, 2, *done.Memberships)
+	assert.Empty(t, br.started, "Only two reloads should have run")
+}
+
+func TestShouldRunOneReloadAtATime(t *testing.T) {
+	br := newBlockingReloader()
+	rj := newReloadJobs(br)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rj.submit(false)
+		}()
+	}
+	wg.Wait()
+
+	br.awaitStart(t)
+	select {
+	case <-br.started:
+		t.Fatal("A second reload should not start while one runs")
+	case <-time.After(20 * time.Millisecond):
+	}
+	br.release <- nil
+	br.awaitStart(t)
+	br.release <- nil
+}
+
+func TestShouldForgetTheOldestJobs(t *testing.T) {
+	rj := newReloadJobs(nil)
+	rj.remember(&reloadJob{ID: "first"})
+	for i := 0; i < maxReloadJobs; i++ {
+		rj.remember(&reloadJob{ID: time.Duration(i).String()})
+	}
+	_, found := rj.get("first")
+	assert.False(t, found)
+	assert.Equal(t, maxReloadJobs, len(rj.jobs))
+}
@@ -0,0 +1,11 @@
+package main
+
+const membershipRoleType = "MembershipRole"
+
+type role struct {
+	UUID                   string                 `json:"uuid"`
+	PrefLabel              string                 `json:"prefLabel"`
+	Type                   string                 `json:"type"`
+	BroaderUUIDs           []string               `json:"broaderUUIDs,omitempty"`
+	AlternativeIdentifiers alternativeIdentifiers `json:"alternativeIdentifiers"`
+}
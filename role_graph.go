@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+const rolesSheet = "roles"
+
+// Problems found in the role hierarchy when the roles sheet is loaded
+const (
+	missingRoleUUIDReason    = "missingRoleUuid"
+	duplicateRoleUUIDReason  = "duplicateRoleUuid"
+	duplicatePreflabelReason = "duplicatePreflabel"
+	invalidPreflabelReason   = "invalidPreflabel"
+	danglingParentReason     = "danglingParent"
+	roleCycleReason          = "roleCycle"
+)
+
+// The roles sheet contains roles that can't be part of the hierarchy
+type roleGraphError struct {
+	problems []rejectedRecord
+}
+
+func (e *roleGraphError) Error() string {
+	msgs := make([]string, 0, len(e.problems))
+	for _, p := range e.problems {
+		msgs = append(msgs, fmt.Sprintf("row %d: %s", p.Row, p.Message))
+	}
+	return "Roles sheet is invalid: " + strings.Join(msgs, "; ")
+}
+
+type roleNode struct {
+	index int
+	role  berthaRole
+}
+
+// Checks the role hierarchy for roles without UUID, duplicate UUIDs and preflabels, preflabels that can't be written
+// in the role column of the authors, parents that don't exist and cycles.
+// The roles that have a problem, or descend from one that has, are returned as rejected and left out of the valid roles.
+func validateRoles(roles []berthaRole) ([]berthaRole, []rejectedRecord) {
+	rejected := make(map[string]rejectedRecord)
+	var problems []rejectedRecord
+	reject := func(n roleNode, reason string, format string, args ...interface{}) {
+		r := rejectedRole(n, reason, fmt.Sprintf(format, args...))
+		problems = append(problems, r)
+		if n.role.UUID != "" {
+			rejected[n.role.UUID] = r
+		}
+	}
+
+	nodes := make(map[string]roleNode)
+	labels := make(map[string]roleNode)
+	var ordered []roleNode
+	for i, r := range roles {
+		if r.isBlank() {
+			continue
+		}
+		n := roleNode{index: i, role: r}
+		if strings.TrimSpace(r.UUID) == "" {
+			reject(n, missingRoleUUIDReason, `Role "%s" has no UUID`, r.Preflabel)
+			continue
+		}
+		if first, found := nodes[r.UUID]; found {
+			// Not marked as rejected by UUID, as the first role with this UUID stays valid
+			problems = append(problems, rejectedRole(n, duplicateRoleUUIDReason,
+				fmt.Sprintf(`Role "%s" has the same UUID as "%s" at row %d`, r.Preflabel, first.role.Preflabel, sheetRow(first.index))))
+			continue
+		}
+		if strings.ContainsAny(r.Preflabel, roleSeparators) {
+			reject(n, invalidPreflabelReason, `Role "%s" has a role separator in its preflabel`, r.Preflabel)
+			continue
+		}
+		if first, found := labels[r.Preflabel]; found {
+			reject(n, duplicatePreflabelReason, `Role "%s" has the same preflabel as the role at row %d`, r.Preflabel, sheetRow(first.index))
+			continue
+		}
+		nodes[r.UUID] = n
+		labels[r.Preflabel] = n
+		ordered = append(ordered, n)
+	}
+
+	for _, n := range ordered {
+		if p := n.role.ParentUUID; p != "" {
+			if _, found := nodes[p]; !found {
+				reject(n, danglingParentReason, `Parent role "%s" of "%s" is not found`, p, n.role.Preflabel)
+			}
+		}
+	}
+
+	for _, n := range ordered {
+		if _, found := rejected[n.role.UUID]; found {
+			continue
+		}
+		if cycle := findCycle(n, nodes); cycle != nil {
+			for _, c := range cycle {
+				if _, found := rejected[c.role.UUID]; !found {
+					reject(c, roleCycleReason, `Role "%s" is its own ancestor`, c.role.Preflabel)
+				}
+			}
+		}
+	}
+
+	// Descendants of rejected roles would get an incomplete hierarchy, so they are quarantined as well
+	for _, n := range ordered {
+		if _, found := rejected[n.role.UUID]; found {
+			continue
+		}
+		for p := n.role.ParentUUID; p != ""; p = nodes[p].role.ParentUUID {
+			if ancestor, found := rejected[p]; found {
+				reject(n, brokenParentChainReason, `Ancestor role at row %d of "%s" is invalid`, ancestor.Row, n.role.Preflabel)
+				break
+			}
+		}
+	}
+
+	var valid []berthaRole
+	for _, n := range ordered {
+		if _, found := rejected[n.role.UUID]; !found {
+			valid = append(valid, n.role)
+		}
+	}
+	sort.SliceStable(problems, func(i, j int) bool { return problems[i].Row < problems[j].Row })
+	return valid, problems
+}
+
+// Returns the roles forming a cycle in the ancestors of the given role, if there is one
+func findCycle(n roleNode, nodes map[string]roleNode) []roleNode {
+	var path []roleNode
+	visited := make(map[string]int)
+	for current, found := n, true; found; current, found = nodes[current.role.ParentUUID] {
+		if at, seen := visited[current.role.UUID]; seen {
+			return path[at:]
+		}
+		visited[current.role.UUID] = len(path)
+		path = append(path, current)
+	}
+	return nil
+}
+
+func rejectedRole(n roleNode, reason string, msg string) rejectedRecord {
+	return rejectedRecord{
+		Sheet:   rolesSheet,
+		Row:     sheetRow(n.index),
+		ID:      n.role.UUID,
+		Name:    n.role.Preflabel,
+		Reason:  reason,
+		Message: msg,
+	}
+}
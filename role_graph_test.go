@@ -0,0 +1,82 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+//For fixtures see fixtures_test.go
+
+var editorRole = berthaRole{UUID: "0d9d3b21-cf5c-4c5e-9b2a-6a29f61b7b56", Preflabel: "Editor", ParentUUID: aRoleUUID}
+
+func TestShouldAcceptValidRoleHierarchy(t *testing.T) {
+	valid, problems := validateRoles([]berthaRole{aBerthaRole, anotherBerthaRole, editorRole})
+	assert.Empty(t, problems)
+	assert.Equal(t, []berthaRole{aBerthaRole, anotherBerthaRole, editorRole}, valid)
+}
+
+func TestShouldRejectRolesInACycleAndTheirDescendants(t *testing.T) {
+	selfParent := berthaRole{UUID: yetAnotherRoleUUID, Preflabel: "Hero", ParentUUID: aRoleUUID}
+	valid, problems := validateRoles([]berthaRole{aBerthaRole, selfParent, editorRole, anotherBerthaRoleWithLabel("Villain")})
+
+	assert.Equal(t, []berthaRole{anotherBerthaRoleWithLabel("Villain")}, valid)
+	assert.Equal(t, 3, len(problems))
+	assert.Equal(t, roleCycleReason, problems[0].Reason)
+	assert.Equal(t, aRoleUUID, problems[0].ID)
+	assert.Equal(t, roleCycleReason, problems[1].Reason)
+	assert.Equal(t, yetAnotherRoleUUID, problems[1].ID)
+	assert.Equal(t, brokenParentChainReason, problems[2].Reason)
+	assert.Equal(t, editorRole.UUID, problems[2].ID)
+	assert.Equal(t, 4, problems[2].Row)
+}
+
+func TestShouldRejectRoleThatIsItsOwnParent(t *testing.T) {
+	selfParent := berthaRole{UUID: aRoleUUID, Preflabel: aRoleLabel, ParentUUID: aRoleUUID}
+	valid, problems := validateRoles([]berthaRole{selfParent})
+
+	assert.Empty(t, valid)
+	assert.Equal(t, []rejectedRecord{{Sheet: rolesSheet, Row: 2, ID: aRoleUUID, Name: aRoleLabel, Reason: roleCycleReason, Message: `Role "Superhero" is its own ancestor`}}, problems)
+}
+
+func TestShouldRejectRoleWithDanglingParent(t *testing.T) {
+	valid, problems := validateRoles([]berthaRole{aBerthaRole, editorRole})
+
+	assert.Empty(t, valid)
+	assert.Equal(t, 2, len(problems))
+	assert.Equal(t, danglingParentReason, problems[0].Reason)
+	assert.Equal(t, `Parent role "93e60bde-dc80-4ed3-8cc1-a19346c52014" of "Superhero" is not found`, problems[0].Message)
+	assert.Equal(t, brokenParentChainReason, problems[1].Reason)
+}
+
+func TestShouldRejectDuplicatePreflabelsAndUUIDs(t *testing.T) {
+	duplicateLabel := berthaRole{UUID: "5a1e8c1d-7d40-4e47-a4e7-2bb7d3d5a0f2", Preflabel: "Hero"}
+	duplicateUUID := berthaRole{UUID: yetAnotherRoleUUID, Preflabel: "Heroine"}
+	valid, problems := validateRoles([]berthaRole{anotherBerthaRole, duplicateLabel, duplicateUUID, aBerthaRole})
+
+	assert.Equal(t, []berthaRole{anotherBerthaRole, aBerthaRole}, valid, "The first role with a preflabel or UUID should be kept")
+	assert.Equal(t, 2, len(problems))
+	assert.Equal(t, duplicatePreflabelReason, problems[0].Reason)
+	assert.Equal(t, 3, problems[0].Row)
+	assert.Equal(t, duplicateRoleUUIDReason, problems[1].Reason)
+	assert.Equal(t, 4, problems[1].Row)
+}
+
+func TestShouldReportAllRoleProblemsInRoleGraphError(t *testing.T) {
+	_, problems := validateRoles([]berthaRole{aBerthaRole, {Preflabel: "Nobody"}})
+	err := &roleGraphError{problems: problems}
+	assert.EqualError(t, err, `Roles sheet is invalid: row 2: Parent role "93e60bde-dc80-4ed3-8cc1-a19346c52014" of "Superhero" is not found; row 3: Role "Nobody" has no UUID`)
+}
+
+func anotherBerthaRoleWithLabel(label string) berthaRole {
+	return berthaRole{UUID: "c1b5e6a4-2f0d-4a49-b2f2-3d3f4c1c9b8e", Preflabel: label}
+}
+
+func TestShouldRejectPreflabelWithARoleSeparator(t *testing.T) {
+	weekend := berthaRole{UUID: "5a1e8c1d-7d40-4e47-a4e7-2bb7d3d5a0f2", Preflabel: "Editor, Weekend"}
+	separated := berthaRole{UUID: yetAnotherRoleUUID, Preflabel: "Editor; Weekend"}
+	valid, problems := validateRoles([]berthaRole{weekend, separated})
+
+	assert.Equal(t, []berthaRole{weekend}, valid, "A comma is not a role separator")
+	assert.Equal(t, []rejectedRecord{{Sheet: rolesSheet, Row: 3, ID: yetAnotherRoleUUID, Name: "Editor; Weekend", Reason: invalidPreflabelReason, Message: `Role "Editor; Weekend" has a role separator in its preflabel`}}, problems)
+}
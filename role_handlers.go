@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"github.com/gorilla/mux"
+)
+
+type roleHandler struct {
+	roleService roleService
+}
+
+func newRoleHandler(rs roleService) roleHandler {
+	return roleHandler{
+		roleService: rs,
+	}
+}
+
+func (rh *roleHandler) getRolesCount(writer http.ResponseWriter, req *http.Request) {
+	if !requireLoadedData(writer, rh.roleService) {
+		return
+	}
+	writeCacheHeaders(writer, rh.roleService)
+	c := rh.roleService.getRoleCount()
+	var buffer bytes.Buffer
+	buffer.WriteString(fmt.Sprintf(`%v`, c))
+	buffer.WriteTo(writer)
+}
+
+func (rh *roleHandler) getRoleUuids(writer http.ResponseWriter, req *http.Request) {
+	if !requireLoadedData(writer, rh.roleService) {
+		return
+	}
+	writeCacheHeaders(writer, rh.roleService)
+	uuids := rh.roleService.getRoleUuids()
+	writeStreamResponse(uuids, writer)
+}
+
+func (rh *roleHandler) getRoleByUuid(writer http.ResponseWriter, req *http.Request) {
+	vars := mux.Vars(req)
+	uuid := vars["uuid"]
+	if !requireLoadedData(writer, rh.roleService) {
+		return
+	}
+	writeCacheHeaders(writer, rh.roleService)
+	r := rh.roleService.getRoleByUuid(uuid)
+	writeJSONResponse(r, !reflect.DeepEqual(r, role{}), "Role not found", writer)
+}
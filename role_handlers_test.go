@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+)
+
+//For fixtures see fixtures_test.go
+
+func TestRolesEndpoints(t *testing.T) {
+	runConceptEndpointTests(t, []conceptEndpointTest{
+		{
+			name:   "count",
+			method: "getRoleCount",
+			result: 2,
+			path:   "/transformers/roles/__count",
+			status: http.StatusOK,
+			body:   "2",
+		},
+		{
+			name:   "ids",
+			method: "getRoleUuids",
+			result: []string{aRoleUUID},
+			path:   "/transformers/roles/__ids",
+			status: http.StatusOK,
+			body:   "{\"id\":\"" + aRoleUUID + "\"}\n",
+		},
+		{
+			name:     "role",
+			method:   "getRoleByUuid",
+			args:     []interface{}{aRoleUUID},
+			result:   expectedRole,
+			path:     "/transformers/roles/" + aRoleUUID,
+			status:   http.StatusOK,
+			bodyFile: "test-resources/transformed-role-output.json",
+		},
+		{
+			name:   "role not found",
+			method: "getRoleByUuid",
+			args:   []interface{}{aRoleUUID},
+			result: role{},
+			path:   "/transformers/roles/" + aRoleUUID,
+			status: http.StatusNotFound,
+		},
+	})
+}
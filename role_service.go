@@ -0,0 +1,8 @@
+package main
+
+type roleService interface {
+	staleDataReporter
+	getRoleCount() int
+	getRoleUuids() []string
+	getRoleByUuid(uuid string) role
+}
@@ -0,0 +1,47 @@
+package main
+
+import "time"
+
+// Everything transformed from one pair of Bertha authors and roles sheets.
+// A snapshot is never modified once it is served.
+type snapshot struct {
+	memberships map[string]membership
+	// Content hash of every membership, to find the memberships changed between two snapshots
+	membershipHashes map[string]string
+	people           map[string]person
+	roles            map[string]role
+	rejected         []rejectedRecord
+	// Author records read from the sheet, the rejected ones included
+	authorCount int
+	loaded      bool
+	// Incremented by every successful refresh, and carried over by snapshots read back from disk
+	version uint64
+	// Hash of the memberships, equal on every replica serving the same memberships
+	contentHash string
+	createdAt   time.Time
+	sources     sourcePair
+}
+
+// The versions of the authors and roles sheets read together to build a snapshot
+type sourcePair struct {
+	Authors sourceVersion `json:"authors"`
+	Roles   sourceVersion `json:"roles"`
+}
+
+func newSnapshot() *snapshot {
+	return &snapshot{
+		memberships:      make(map[string]membership),
+		membershipHashes: make(map[string]string),
+		people:           make(map[string]person),
+		roles:            make(map[string]role),
+		rejected:         []rejectedRecord{},
+	}
+}
+
+func (s *snapshot) membershipUuids() []string {
+	uuids := make([]string, 0, len(s.memberships))
+	for uuid := range s.memberships {
+		uuids = append(uuids, uuid)
+	}
+	return uuids
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	snapshotFilePrefix    = "snapshot-"
+	snapshotFileExtension = ".json"
+	snapshotFilesKept     = 5
+)
+
+// The JSON form of a snapshot on disk. The content hashes are not written, they are computed again on load.
+type persistedSnapshot struct {
+	Version     uint64                `json:"version"`
+	CreatedAt   time.Time             `json:"createdAt"`
+	Sources     sourcePair            `json:"sources"`
+	Memberships map[string]membership `json:"memberships"`
+	People      map[string]person     `json:"people"`
+	Roles       map[string]role       `json:"roles"`
+	Rejected    []rejectedRecord      `json:"rejected"`
+	AuthorCount int                   `json:"authorCount"`
+}
+
+// Keeps the snapshots of the last successful refreshes in a directory, one file per version,
+// so that the service can start from the newest one while Bertha is down
+type snapshotStore struct {
+	dir  string
+	keep int
+}
+
+func newSnapshotStore(dir string) (*snapshotStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &snapshotStore{dir: dir, keep: snapshotFilesKept}, nil
+}
+
+// The snapshot is written to a temporary file first and renamed, so a crash never leaves half a snapshot behind
+func (ss *snapshotStore) save(s *snapshot) error {
+	tmp, err := ioutil.TempFile(ss.dir, "."+snapshotFilePrefix)
+	if err != nil {
+		return err
+	}
+	ps := persistedSnapshot{
+		Version:     s.version,
+		CreatedAt:   s.createdAt,
+		Sources:     s.sources,
+		Memberships: s.memberships,
+		People:      s.people,
+		Roles:       s.roles,
+		Rejected:    s.rejected,
+		AuthorCount: s.authorCount,
+	}
+	if err := json.NewEncoder(tmp).Encode(ps); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), ss.path(s.version)); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return ss.prune()
+}
+
+// Returns the newest snapshot that can be read, or nil when there is none.
+// An unreadable file is logged and skipped in favour of the one before it.
+func (ss *snapshotStore) latest() *snapshot {
+	files, err := ss.files()
+	if err != nil {
+		log.Errorf("Failed to list the snapshots in %s: %v", ss.dir, err)
+		return nil
+	}
+	for i := len(files) - 1; i >= 0; i-- {
+		s, err := readSnapshotFile(files[i])
+		if err != nil {
+			log.Warnf("Skipping snapshot %s: %v", files[i], err)
+			continue
+		}
+		return s
+	}
+	return nil
+}
+
+func (ss *snapshotStore) prune() error {
+	files, err := ss.files()
+	if err != nil {
+		return err
+	}
+	for len(files) > ss.keep {
+		if err := os.Remove(files[0]); err != nil {
+			return err
+		}
+		files = files[1:]
+	}
+	return nil
+}
+
+// The version is zero padded in the file name, so the lexical order of the files is the order of the versions
+func (ss *snapshotStore) path(version uint64) string {
+	return filepath.Join(ss.dir, fmt.Sprintf("%s%020d%s", snapshotFilePrefix, version, snapshotFileExtension))
+}
+
+func (ss *snapshotStore) files() ([]string, error) {
+	files, err := filepath.Glob(filepath.Join(ss.dir, snapshotFilePrefix+"*"+snapshotFileExtension))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+func readSnapshotFile(path string) (*snapshot, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var ps persistedSnapshot
+	if err := json.NewDecoder(f).Decode(&ps); err != nil {
+		return nil, err
+	}
+
+	s := newSnapshot()
+	s.version = ps.Version
+	s.createdAt = ps.CreatedAt
+	s.sources = ps.Sources
+	for uuid, m := range ps.Memberships {
+		s.memberships[uuid] = m
+		s.membershipHashes[uuid] = contentHash(m)
+	}
+	s.contentHash = membershipsHash(s.membershipHashes)
+	for uuid, p := range ps.People {
+		s.people[uuid] = p
+	}
+	for uuid, r := range ps.Roles {
+		s.roles[uuid] = r
+	}
+	if ps.Rejected != nil {
+		s.rejected = ps.Rejected
+	}
+	s.authorCount = ps.AuthorCount
+	if s.authorCount == 0 {
+		// Saved before the author records were counted
+		s.authorCount = len(s.people)
+	}
+	s.loaded = true
+	return s, nil
+}
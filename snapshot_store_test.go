@@ -0,0 +1,75 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func aSnapshot(version uint64) *snapshot {
+	s := newSnapshot()
+	s.version = version
+	s.createdAt = time.Date(2017, 6, 1, 10, 0, int(version), 0, time.UTC)
+	s.memberships[membership1.UUID] = membership1
+	s.people[expectedAuthorUUID] = person{Uuid: expectedAuthorUUID, Name: "Martin Wolf"}
+	s.roles[expectedRole.UUID] = expectedRole
+	s.rejected = []rejectedRecord{{Sheet: authorsSheet, Row: 3, Reason: unknownRoleReason, Message: "No role is given"}}
+	s.authorCount = 2
+	s.loaded = true
+	return s
+}
+
+func tempSnapshotStore(t *testing.T) (*snapshotStore, func()) {
+	dir, err := ioutil.TempDir("", "snapshots")
+	assert.Nil(t, err)
+	ss, err := newSnapshotStore(dir)
+	assert.Nil(t, err)
+	return ss, func() { os.RemoveAll(dir) }
+}
+
+func TestShouldReadBackTheLatestSavedSnapshot(t *testing.T) {
+	ss, cleanup := tempSnapshotStore(t)
+	defer cleanup()
+
+	assert.Nil(t, ss.latest(), "An empty directory has no snapshot")
+	assert.Nil(t, ss.save(aSnapshot(9)))
+	assert.Nil(t, ss.save(aSnapshot(10)))
+
+	s := ss.latest()
+	expected := aSnapshot(10)
+	expected.membershipHashes[membership1.UUID] = contentHash(membership1)
+	expected.contentHash = membershipsHash(expected.membershipHashes)
+	assert.Equal(t, expected, s, "Version 10 should be read back as it was saved")
+}
+
+func TestShouldKeepOnlyTheNewestSnapshotFiles(t *testing.T) {
+	ss, cleanup := tempSnapshotStore(t)
+	defer cleanup()
+
+	for v := uint64(1); v <= snapshotFilesKept+2; v++ {
+		assert.Nil(t, ss.save(aSnapshot(v)))
+	}
+	files, err := ss.files()
+	assert.Nil(t, err)
+	assert.Equal(t, snapshotFilesKept, len(files))
+	assert.Equal(t, ss.path(3), files[0], "The oldest snapshots should be removed")
+}
+
+func TestShouldSkipASnapshotThatCantBeRead(t *testing.T) {
+	ss, cleanup := tempSnapshotStore(t)
+	defer cleanup()
+
+	assert.Nil(t, ss.save(aSnapshot(1)))
+	assert.Nil(t, ioutil.WriteFile(ss.path(2), []byte(`{"version": 2, "memberships": {`), 0644))
+
+	s := ss.latest()
+	assert.NotNil(t, s)
+	assert.Equal(t, uint64(1), s.version, "The older readable snapshot should be used")
+
+	files, _ := filepath.Glob(filepath.Join(ss.dir, "."+snapshotFilePrefix+"*"))
+	assert.Empty(t, files, "No temporary file should be left behind")
+}
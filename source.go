@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"time"
+)
+
+// Where the authors or the roles sheet is read from
+type source interface {
+	read(ctx context.Context) (*sourceContent, error)
+	checkConnectivity() error
+	String() string
+}
+
+// Implemented by the sources that stop calling a failing remote for a while
+type circuitBreakerSource interface {
+	checkCircuitBreaker() error
+}
+
+type sourceContent struct {
+	body        io.ReadCloser
+	contentType string
+	// HTTP validators of the response, or the modification time of local files
+	etag         string
+	lastModified string
+}
+
+// Which version of a sheet was read, and when
+type sourceVersion struct {
+	URL          string    `json:"url"`
+	FetchedAt    time.Time `json:"fetchedAt"`
+	ETag         string    `json:"etag,omitempty"`
+	LastModified string    `json:"lastModified,omitempty"`
+}
+
+// Chooses the source by the scheme of the URL: http:// and https:// for Bertha,
+// file:// for a local JSON file or a directory of JSON fixtures
+func newSource(rawURL string) (source, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		return newBerthaSource(rawURL), nil
+	case "file":
+		// file:///absolute/path, or file://relative/path where the first element of the path is parsed as host
+		path := u.Host + u.Path
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if info.IsDir() {
+			return &dirSource{path: path}, nil
+		}
+		return &fileSource{path: path}, nil
+	default:
+		return nil, fmt.Errorf(`Unsupported source URL "%s", expected http://, https:// or file://`, rawURL)
+	}
+}
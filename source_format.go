@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"reflect"
+	"strings"
+	"time"
+)
+
+const (
+	autoFormat = "auto"
+	jsonFormat = "json"
+	csvFormat  = "csv"
+)
+
+const (
+	jsonContentType = "application/json"
+	csvContentType  = "text/csv"
+)
+
+func validateSourceFormat(format string) error {
+	switch format {
+	case "", autoFormat, jsonFormat, csvFormat:
+		return nil
+	default:
+		return fmt.Errorf(`Unsupported source format "%s", expected %s, %s or %s`, format, autoFormat, jsonFormat, csvFormat)
+	}
+}
+
+// How the records of a sheet are read
+type sheetOptions struct {
+	// Name of the sheet in the rejected records
+	sheet string
+	// autoFormat chooses the format by the Content-Type of the response
+	format string
+	// Largest body accepted, zero meaning no limit
+	maxBytes int64
+	// Rejects the records with fields of the wrong type instead of failing the whole sheet
+	skipInvalid bool
+}
+
+// Decodes the records of the source in the given format, or in the format of its Content-Type,
+// and returns the version of the sheet that was read with the records rejected while decoding.
+// Anything but CSV is decoded as JSON, as Bertha serves JSON.
+func readSource(ctx context.Context, src source, opts sheetOptions, records interface{}) (sourceVersion, []rejectedRecord, error) {
+	content, err := src.read(ctx)
+	if err != nil {
+		return sourceVersion{}, nil, err
+	}
+	defer content.body.Close()
+	version := sourceVersion{URL: src.String(), FetchedAt: time.Now().UTC(), ETag: content.etag, LastModified: content.lastModified}
+
+	format := opts.format
+	if err := checkContentType(src, content.contentType, format); err != nil {
+		return sourceVersion{}, nil, err
+	}
+	if format == "" || format == autoFormat {
+		format = formatOf(content.contentType)
+	}
+	data, err := readBody(src, content.body, opts.maxBytes)
+	if err != nil {
+		return sourceVersion{}, nil, err
+	}
+	if format == csvFormat {
+		if err := decodeCSV(bytes.NewReader(data), records); err != nil {
+			return sourceVersion{}, nil, newSourceError(src, malformedBodyReason, "%s did not return a valid CSV sheet: %s", src, err.Error())
+		}
+		return version, nil, nil
+	}
+	rejected, err := decodeJSON(src, opts.sheet, data, records, opts.skipInvalid)
+	if err != nil {
+		return sourceVersion{}, nil, err
+	}
+	return version, rejected, nil
+}
+
+func formatOf(contentType string) string {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err == nil && (mediaType == csvContentType || mediaType == "application/csv") {
+		return csvFormat
+	}
+	return jsonFormat
+}
+
+// Decodes a CSV sheet, such as a Google Sheets "publish as CSV" export, into a pointer to a slice of structs.
+// Columns are mapped to the JSON fields of the struct by their header, ignoring case, spaces, dashes and underscores,
+// so the "TME Identifier" column fills the "tmeidentifier" field. Columns without a field are ignored.
+// Blank rows are decoded as blank records, which are skipped when transformed, so every record keeps its sheet row.
+func decodeCSV(r io.Reader, records interface{}) error {
+	body, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	var rows [][]string
+	for i, line := range splitCSVRows(body) {
+		row, err := parseCSVRow(line)
+		if err != nil {
+			return fmt.Errorf("row %d: %s", i+1, err.Error())
+		}
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 {
+		return json.Unmarshal([]byte("[]"), records)
+	}
+
+	fields := jsonFieldsByColumn(records)
+	columns := make([]string, len(rows[0]))
+	for i, header := range rows[0] {
+		columns[i] = fields[normalizeColumn(header)]
+	}
+
+	objects := make([]map[string]string, 0, len(rows)-1)
+	for _, row := range rows[1:] {
+		o := make(map[string]string)
+		if isBlankRow(row) {
+			objects = append(objects, o)
+			continue
+		}
+		for i, value := range row {
+			if i < len(columns) && columns[i] != "" {
+				o[columns[i]] = value
+			}
+		}
+		objects = append(objects, o)
+	}
+
+	data, err := json.Marshal(objects)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, records)
+}
+
+// Splits the sheet into its rows, keeping the empty lines that encoding/csv would skip.
+// A line break inside a quoted cell doesn't end the row.
+func splitCSVRows(data []byte) []string {
+	var rows []string
+	inQuotes := false
+	start := 0
+	for i, b := range data {
+		switch b {
+		case '"':
+			inQuotes = !inQuotes
+		case '\n':
+			if !inQuotes {
+				rows = append(rows, strings.TrimSuffix(string(data[start:i]), "\r"))
+				start = i + 1
+			}
+		}
+	}
+	if start < len(data) {
+		rows = append(rows, string(data[start:]))
+	}
+	return rows
+}
+
+func parseCSVRow(line string) ([]string, error) {
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.FieldsPerRecord = -1
+	row, err := reader.Read()
+	if err == io.EOF {
+		return nil, nil
+	}
+	return row, err
+}
+
+func jsonFieldsByColumn(records interface{}) map[string]string {
+	fields := make(map[string]string)
+	t := reflect.TypeOf(records)
+	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Slice {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return fields
+	}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[normalizeColumn(name)] = name
+		}
+	}
+	return fields
+}
+
+func normalizeColumn(name string) string {
+	return strings.ToLower(strings.NewReplacer(" ", "", "-", "", "_", "").Replace(strings.TrimSpace(name)))
+}
+
+func isBlankRow(row []string) bool {
+	for _, value := range row {
+		if strings.TrimSpace(value) != "" {
+			return false
+		}
+	}
+	return true
+}
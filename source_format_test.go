@@ -0,0 +1,106 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldDecodeAuthorsFromCSVByHeader(t *testing.T) {
+	file, err := os.Open("test-resources/google-sheets-authors-output.csv")
+	assert.Nil(t, err)
+	defer file.Close()
+
+	var authors []author
+	assert.Nil(t, decodeCSV(file, &authors))
+
+	assert.Equal(t, 3, len(authors), "Blank rows should be kept, so every author keeps its sheet row")
+	assert.True(t, authors[1].isBlank())
+	assert.Equal(t, "Martin Wolf", authors[0].Name)
+	assert.Equal(t, authorRoles{{Name: "Columnist", StartDate: "2010-01-01"}}, authors[0].Roles)
+	assert.Equal(t, "Chief Economics Commentator", authors[0].Jobtitle)
+	assert.Equal(t, "@martinwolf_", authors[0].TwitterHandle)
+	assert.Equal(t, "Martin Wolf is chief economics commentator at the Financial Times, London.", authors[0].Biography)
+	assert.Equal(t, "Q0ItMDAwMDkwMA==-QXV0aG9ycw==", authors[0].TmeIdentifier)
+	assert.Equal(t, "2008-05-01", authors[0].StartDate)
+	assert.Equal(t, "2016-03-31T17:30:00Z", authors[0].EndDate)
+	assert.Equal(t, authorRoles{{Name: "Columnist"}, {Name: "Journalist"}}, authors[2].Roles)
+}
+
+func TestShouldDecodeRolesFromCSVByHeader(t *testing.T) {
+	var roles []berthaRole
+	err := decodeCSV(strings.NewReader("preflabel,uuid,parent_uuid\nColumnist,7ef75a6a-b6bf-4eb7-a1da-03e0acabef1b,33ee38a4-c677-4952-a141-2ae14da3aedd\n"), &roles)
+	assert.Nil(t, err)
+	assert.Equal(t, []berthaRole{{UUID: "7ef75a6a-b6bf-4eb7-a1da-03e0acabef1b", Preflabel: "Columnist", ParentUUID: "33ee38a4-c677-4952-a141-2ae14da3aedd"}}, roles)
+}
+
+func TestShouldKeepEmptyLinesAndLineBreaksInCells(t *testing.T) {
+	var roles []berthaRole
+	err := decodeCSV(strings.NewReader("preflabel,uuid\r\n\"Column\nist\",1\r\n\r\n,,\r\nEditor,2"), &roles)
+	assert.Nil(t, err)
+	assert.Equal(t, []berthaRole{{UUID: "1", Preflabel: "Column\nist"}, {}, {}, {UUID: "2", Preflabel: "Editor"}}, roles, "Every role should stay at the index of its sheet row")
+}
+
+func TestShouldDecodeEmptyCSV(t *testing.T) {
+	var roles []berthaRole
+	assert.Nil(t, decodeCSV(strings.NewReader(""), &roles))
+	assert.Empty(t, roles)
+}
+
+func TestShouldChooseFormatByContentType(t *testing.T) {
+	assert.Equal(t, csvFormat, formatOf("text/csv"))
+	assert.Equal(t, csvFormat, formatOf("text/csv; charset=utf-8"))
+	assert.Equal(t, jsonFormat, formatOf("application/json; charset=utf-8"))
+	assert.Equal(t, jsonFormat, formatOf(""))
+}
+
+func TestShouldReadSourceInTheFormatOfTheFlag(t *testing.T) {
+	var roles []berthaRole
+	_, _, err := readSource(context.Background(), &fileSource{path: "test-resources/google-sheets-roles-output.csv"}, sheetOptions{format: jsonFormat}, &roles)
+	assert.NotNil(t, err, "The CSV file should not be decoded as JSON when the format is forced")
+
+	_, _, err = readSource(context.Background(), &fileSource{path: "test-resources/google-sheets-roles-output.csv"}, sheetOptions{format: autoFormat}, &roles)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(roles))
+}
+
+func TestShouldReadCSVServedAsPlainTextWhenTheFormatIsForced(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		http.ServeFile(w, r, "test-resources/google-sheets-roles-output.csv")
+	}))
+	defer server.Close()
+
+	var roles []berthaRole
+	_, _, err := readSource(context.Background(), newBerthaSource(server.URL), sheetOptions{format: csvFormat}, &roles)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(roles))
+}
+
+func TestShouldReadCSVFileWithoutCSVExtensionWhenTheFormatIsForced(t *testing.T) {
+	data, err := ioutil.ReadFile("test-resources/google-sheets-roles-output.csv")
+	assert.Nil(t, err)
+	f, err := ioutil.TempFile("", "roles")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	_, err = f.Write(data)
+	assert.Nil(t, err)
+	assert.Nil(t, f.Close())
+
+	var roles []berthaRole
+	_, _, err = readSource(context.Background(), &fileSource{path: f.Name()}, sheetOptions{format: csvFormat}, &roles)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(roles))
+}
+
+func TestShouldValidateSourceFormat(t *testing.T) {
+	assert.Nil(t, validateSourceFormat(""))
+	assert.Nil(t, validateSourceFormat(csvFormat))
+	assert.NotNil(t, validateSourceFormat("xml"))
+}
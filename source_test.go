@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShouldChooseSourceByURLScheme(t *testing.T) {
+	s, err := newSource("http://bertha.ig.ft.com/view/publish/gss/123456XYZ/Authors")
+	assert.Nil(t, err)
+	assert.IsType(t, &berthaSource{}, s)
+
+	s, err = newSource("https://bertha.ig.ft.com/view/publish/gss/123456XYZ/Authors")
+	assert.Nil(t, err)
+	assert.IsType(t, &berthaSource{}, s)
+
+	s, err = newSource("file://" + authorsBerthaOutput)
+	assert.Nil(t, err)
+	assert.Equal(t, &fileSource{path: authorsBerthaOutput}, s)
+
+	s, err = newSource("file://test-resources/authors-fixtures")
+	assert.Nil(t, err)
+	assert.Equal(t, &dirSource{path: "test-resources/authors-fixtures"}, s)
+
+	_, err = newSource("file://test-resources/missing.json")
+	assert.NotNil(t, err)
+
+	_, err = newSource("ftp://bertha.ig.ft.com/Authors")
+	assert.NotNil(t, err)
+}
+
+func TestShouldReadAuthorsFromFileAndFixturesDirectory(t *testing.T) {
+	var fromFile []author
+	version, _, err := readSource(context.Background(), &fileSource{path: authorsBerthaOutput}, sheetOptions{format: autoFormat}, &fromFile)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(fromFile))
+	assert.Equal(t, "file://"+authorsBerthaOutput, version.URL)
+	assert.NotEmpty(t, version.LastModified, "The modification time of the file should be recorded")
+
+	var fromDir []author
+	_, _, err = readSource(context.Background(), &dirSource{path: "test-resources/authors-fixtures"}, sheetOptions{format: autoFormat}, &fromDir)
+	assert.Nil(t, err)
+	assert.Equal(t, fromFile, fromDir, "The fixtures should be concatenated in order")
+}
+
+func TestShouldCheckConnectivityOfLocalSources(t *testing.T) {
+	assert.Nil(t, (&fileSource{path: authorsBerthaOutput}).checkConnectivity())
+	assert.NotNil(t, (&fileSource{path: "test-resources/missing.json"}).checkConnectivity())
+	assert.Nil(t, (&dirSource{path: "test-resources/authors-fixtures"}).checkConnectivity())
+	assert.NotNil(t, (&dirSource{path: "test-resources/missing"}).checkConnectivity())
+}
+
+func TestShouldFailToReadInvalidFixture(t *testing.T) {
+	var records []json.RawMessage
+	_, _, err := readSource(context.Background(), &dirSource{path: "test-resources"}, sheetOptions{format: autoFormat}, &records)
+	assert.NotNil(t, err, "The transformed membership fixture is not an array")
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"reflect"
+	"sort"
+	"strings"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Reasons why the response of a source is refused, reported by the reload endpoint and in the logs
+const (
+	unexpectedStatusReason      = "unexpectedStatus"
+	unexpectedContentTypeReason = "unexpectedContentType"
+	bodyTooLargeReason          = "bodyTooLarge"
+	malformedBodyReason         = "malformedBody"
+	mistypedFieldReason         = "mistypedField"
+	tooFewRecordsReason         = "tooFewRecords"
+)
+
+type sourceError struct {
+	source string
+	reason string
+	msg    string
+}
+
+func newSourceError(src source, reason string, format string, args ...interface{}) *sourceError {
+	return &sourceError{source: src.String(), reason: reason, msg: fmt.Sprintf(format, args...)}
+}
+
+func (e *sourceError) Error() string {
+	return e.msg
+}
+
+// Pages rather than data, such as the HTML error page of a proxy served with a 200 status
+var pageMediaTypes = []string{"text/html", "application/xhtml+xml"}
+
+// Only JSON and CSV are accepted when the format is chosen by the Content-Type.
+// A forced format is there to read sources served with a wrong or generic Content-Type,
+// so only pages are refused then. A response without Content-Type is read in the given format.
+func checkContentType(src source, contentType string, format string) error {
+	if contentType == "" {
+		return nil
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return newSourceError(src, unexpectedContentTypeReason, `%s returned unexpected Content-Type "%s"`, src, contentType)
+	}
+	if format != "" && format != autoFormat {
+		for _, page := range pageMediaTypes {
+			if mediaType == page {
+				return newSourceError(src, unexpectedContentTypeReason, `%s returned unexpected Content-Type "%s"`, src, contentType)
+			}
+		}
+		return nil
+	}
+	if mediaType != jsonContentType && !strings.HasSuffix(mediaType, "+json") && formatOf(mediaType) != csvFormat {
+		return newSourceError(src, unexpectedContentTypeReason, `%s returned unexpected Content-Type "%s"`, src, contentType)
+	}
+	return nil
+}
+
+// Reads the whole body, failing as soon as it is larger than maxBytes. Zero means no limit.
+func readBody(src source, body io.Reader, maxBytes int64) ([]byte, error) {
+	if maxBytes <= 0 {
+		return ioutil.ReadAll(body)
+	}
+	data, err := ioutil.ReadAll(io.LimitReader(body, maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > maxBytes {
+		return nil, newSourceError(src, bodyTooLargeReason, "%s returned more than %d bytes", src, maxBytes)
+	}
+	return data, nil
+}
+
+// Decodes a JSON array of records into a pointer to a slice of structs. A field of the wrong type,
+// such as a numeric tmeidentifier, fails the decoding with the rows and fields at fault.
+// When invalid records are skipped, the rows at fault are returned as rejected instead, and decoded as blank records
+// so that every record keeps its sheet row.
+// Fields the struct doesn't have and null values are only logged, as Bertha sends null for empty cells.
+func decodeJSON(src source, sheet string, data []byte, records interface{}, skipInvalid bool) ([]rejectedRecord, error) {
+	slice := reflect.ValueOf(records).Elem()
+	if slice.Type().Elem().Kind() != reflect.Struct {
+		return nil, json.Unmarshal(data, records)
+	}
+
+	var elements []json.RawMessage
+	if err := json.Unmarshal(data, &elements); err != nil {
+		return nil, newSourceError(src, malformedBodyReason, "%s did not return a JSON array of records: %s", src, err.Error())
+	}
+
+	fields := jsonFieldTypes(slice.Type().Elem())
+	unknown := make(map[string]int)
+	null := make(map[string]int)
+	var mistyped []rejectedRecord
+	decoded := reflect.MakeSlice(slice.Type(), 0, len(elements))
+	for i, e := range elements {
+		if problems := checkFieldTypes(e, fields, unknown, null); len(problems) > 0 {
+			mistyped = append(mistyped, mistypedRecord(sheet, i, problems))
+			decoded = reflect.Append(decoded, reflect.Zero(slice.Type().Elem()))
+			continue
+		}
+		record := reflect.New(slice.Type().Elem())
+		if err := json.Unmarshal(e, record.Interface()); err != nil {
+			mistyped = append(mistyped, mistypedRecord(sheet, i, []string{describeTypeError(err)}))
+			decoded = reflect.Append(decoded, reflect.Zero(slice.Type().Elem()))
+			continue
+		}
+		decoded = reflect.Append(decoded, record.Elem())
+	}
+
+	for name, count := range unknown {
+		log.WithFields(log.Fields{"source": src.String(), "field": name, "records": count}).Warn("Unknown field is ignored")
+	}
+	for name, count := range null {
+		log.WithFields(log.Fields{"source": src.String(), "field": name, "records": count}).Warn("Null field is read as empty")
+	}
+	if len(mistyped) > 0 && !skipInvalid {
+		msgs := make([]string, 0, len(mistyped))
+		for _, r := range mistyped {
+			msgs = append(msgs, fmt.Sprintf("row %d %s", r.Row, r.Message))
+		}
+		return nil, newSourceError(src, mistypedFieldReason, "%s returned fields of the wrong type: %s", src, strings.Join(msgs, "; "))
+	}
+	slice.Set(decoded)
+	return mistyped, nil
+}
+
+// Returns the fields of the element that don't have the type of the struct field, and counts the unknown and null ones
+func checkFieldTypes(e json.RawMessage, fields map[string]reflect.Type, unknown map[string]int, null map[string]int) []string {
+	var object map[string]json.RawMessage
+	if err := json.Unmarshal(e, &object); err != nil {
+		return []string{"is not a JSON object"}
+	}
+	var problems []string
+	for _, name := range sortedFieldNames(object) {
+		field, found := fields[strings.ToLower(name)]
+		switch {
+		case !found:
+			unknown[name]++
+		case string(bytes.TrimSpace(object[name])) == "null":
+			null[name]++
+		default:
+			if err := json.Unmarshal(object[name], reflect.New(field).Interface()); err != nil {
+				problems = append(problems, fmt.Sprintf(`field "%s" %s`, name, describeTypeError(err)))
+			}
+		}
+	}
+	return problems
+}
+
+func mistypedRecord(sheet string, index int, problems []string) rejectedRecord {
+	return rejectedRecord{
+		Sheet:   sheet,
+		Row:     sheetRow(index),
+		Reason:  mistypedFieldReason,
+		Message: strings.Join(problems, ", "),
+	}
+}
+
+// Indexed by the lower case JSON name, as encoding/json matches names regardless of case
+func jsonFieldTypes(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name != "" && name != "-" {
+			fields[strings.ToLower(name)] = t.Field(i).Type
+		}
+	}
+	return fields
+}
+
+func sortedFieldNames(object map[string]json.RawMessage) []string {
+	names := make([]string, 0, len(object))
+	for name := range object {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func describeTypeError(err error) string {
+	if ute, ok := err.(*json.UnmarshalTypeError); ok {
+		return fmt.Sprintf("is a %s, expected a %s", ute.Value, ute.Type)
+	}
+	return err.Error()
+}
+
+func checkMinimumRecords(src source, kind string, count int, minimum int) error {
+	if count < minimum {
+		return newSourceError(src, tooFewRecordsReason, "%s returned %d %s, at least %d are expected", src, count, kind, minimum)
+	}
+	return nil
+}
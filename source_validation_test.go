@@ -0,0 +1,120 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var authorsFileSource = &fileSource{path: authorsBerthaOutput}
+
+func TestShouldReportFieldsOfTheWrongType(t *testing.T) {
+	var authors []author
+	_, err := decodeJSON(authorsFileSource, authorsSheet, []byte(`[
+		{"name": "Martin Wolf", "role": "Columnist", "tmeidentifier": "Q0ItMDAwMDkwMA==-QXV0aG9ycw=="},
+		{"name": "Lucy Kellaway", "role": "Columnist", "tmeidentifier": 926}
+	]`), &authors, false)
+
+	assert.IsType(t, &sourceError{}, err)
+	assert.Equal(t, mistypedFieldReason, err.(*sourceError).reason)
+	assert.Contains(t, err.Error(), `row 3 field "tmeidentifier" is a number, expected a string`)
+	assert.Empty(t, authors, "Nothing should be decoded from a sheet with mistyped fields")
+}
+
+func TestShouldRejectRowsWithFieldsOfTheWrongTypeWhenSkippingInvalidRecords(t *testing.T) {
+	var authors []author
+	rejected, err := decodeJSON(authorsFileSource, authorsSheet, []byte(`[
+		{"name": "Martin Wolf", "jobtitle": 2, "role": "Columnist", "tmeidentifier": "Q0ItMDAwMDkwMA==-QXV0aG9ycw=="},
+		{"name": "Lucy Kellaway", "role": "Columnist", "tmeidentifier": "Q0ItMDAwMDkyNg==-QXV0aG9ycw=="}
+	]`), &authors, true)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []rejectedRecord{{Sheet: authorsSheet, Row: 2, Reason: mistypedFieldReason, Message: `field "jobtitle" is a number, expected a string`}}, rejected)
+	assert.Equal(t, 2, len(authors), "The mistyped row should be kept blank, so the next rows keep their index")
+	assert.True(t, authors[0].isBlank())
+	assert.Equal(t, "Lucy Kellaway", authors[1].Name)
+}
+
+func TestShouldAcceptUnknownAndNullFields(t *testing.T) {
+	var authors []author
+	_, err := decodeJSON(authorsFileSource, authorsSheet, []byte(`[
+		{"name": "Lucy Kellaway", "role": "Columnist", "twitterhandle": null, "desk": "Work & Careers", "tmeidentifier": "Q0ItMDAwMDkyNg==-QXV0aG9ycw=="}
+	]`), &authors, false)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []author{{Name: "Lucy Kellaway", Roles: authorRoles{{Name: "Columnist"}}, TmeIdentifier: "Q0ItMDAwMDkyNg==-QXV0aG9ycw=="}}, authors)
+}
+
+func TestShouldRefuseABodyThatIsNotAnArray(t *testing.T) {
+	var roles []berthaRole
+	_, err := decodeJSON(authorsFileSource, authorsSheet, []byte(`{"error": "Spreadsheet not found"}`), &roles, false)
+	assert.IsType(t, &sourceError{}, err)
+	assert.Equal(t, malformedBodyReason, err.(*sourceError).reason)
+}
+
+func TestShouldCheckContentType(t *testing.T) {
+	assert.Nil(t, checkContentType(authorsFileSource, "application/json; charset=utf-8", autoFormat))
+	assert.Nil(t, checkContentType(authorsFileSource, "text/csv", autoFormat))
+	assert.Nil(t, checkContentType(authorsFileSource, "", autoFormat))
+	err := checkContentType(authorsFileSource, "text/html; charset=utf-8", autoFormat)
+	assert.IsType(t, &sourceError{}, err)
+	assert.Equal(t, unexpectedContentTypeReason, err.(*sourceError).reason)
+}
+
+func TestShouldCheckContentTypeWhenTheFormatIsForced(t *testing.T) {
+	assert.Nil(t, checkContentType(authorsFileSource, "application/json; charset=utf-8", jsonFormat))
+	assert.Nil(t, checkContentType(authorsFileSource, "text/csv; charset=utf-8", csvFormat))
+	assert.Nil(t, checkContentType(authorsFileSource, "", csvFormat))
+
+	for _, format := range []string{jsonFormat, csvFormat} {
+		err := checkContentType(authorsFileSource, "text/html; charset=utf-8", format)
+		assert.IsType(t, &sourceError{}, err, "An HTML page should be refused when the format is %s", format)
+		assert.Equal(t, unexpectedContentTypeReason, err.(*sourceError).reason)
+	}
+	assert.Nil(t, checkContentType(authorsFileSource, "application/json", csvFormat), "A forced format should override the Content-Type")
+	assert.Nil(t, checkContentType(authorsFileSource, "text/plain; charset=utf-8", csvFormat), "A forced format should override the Content-Type")
+}
+
+func TestShouldRefuseABodyLargerThanTheMaximum(t *testing.T) {
+	data, err := readBody(authorsFileSource, strings.NewReader("[]"), 2)
+	assert.Nil(t, err)
+	assert.Equal(t, "[]", string(data))
+
+	_, err = readBody(authorsFileSource, strings.NewReader("[{}]"), 2)
+	assert.IsType(t, &sourceError{}, err)
+	assert.Equal(t, bodyTooLargeReason, err.(*sourceError).reason)
+}
+
+func TestShouldRefuseAnErrorStatusOrAnHTMLPageFromBertha(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		w.Write([]byte("<html><body>Maintenance</body></html>"))
+	}))
+	defer server.Close()
+
+	var authors []author
+	_, _, err := readSource(context.Background(), newBerthaSource(server.URL+"/missing"), sheetOptions{format: autoFormat}, &authors)
+	assert.IsType(t, &sourceError{}, err)
+	assert.Equal(t, unexpectedStatusReason, err.(*sourceError).reason)
+	assert.Equal(t, server.URL+"/missing", err.(*sourceError).source)
+
+	for _, format := range []string{autoFormat, jsonFormat, csvFormat} {
+		_, _, err = readSource(context.Background(), newBerthaSource(server.URL+"/maintenance"), sheetOptions{format: format}, &authors)
+		assert.IsType(t, &sourceError{}, err, "The HTML page should be refused when the format is %s", format)
+		assert.Equal(t, unexpectedContentTypeReason, err.(*sourceError).reason)
+	}
+}
+
+func TestShouldFailRefreshWhenThereAreTooFewAuthors(t *testing.T) {
+	_, err := newRefreshedBerthaService(berthaServiceConfig{authorsURL: "file://" + authorsBerthaOutput, rolesURL: "file://" + rolesBerthaOutput, minAuthors: 3})
+	assert.EqualError(t, err, "file://"+authorsBerthaOutput+" returned 2 authors, at least 3 are expected")
+	assert.Equal(t, tooFewRecordsReason, err.(*sourceError).reason)
+}
@@ -2,4 +2,6 @@ package main
 
 type transformer interface {
 	toMembership(author, map[string]berthaRole, map[string]berthaRole) (membership, error)
+	toPerson(author) person
+	toRole(berthaRole) role
 }